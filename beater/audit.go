@@ -0,0 +1,89 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// auditTransport is the transport of the kernel audit records
+const auditTransport = "audit"
+
+// auditFields splits an audit record like
+//
+//	type=SYSCALL msg=audit(1364481363.243:24287): arch=c000003e syscall=2 success=no comm="cat"
+//
+// into its key=value components. Quoted values are unquoted, words without a key are skipped.
+// The audit(timestamp:serial) header of msg yields the sequence of the record, a quoted msg of
+// key=value components is split up too.
+func auditFields(message string) common.MapStr {
+	fields := common.MapStr{}
+	for rest := strings.TrimSpace(message); rest != ""; rest = strings.TrimLeft(rest, " ") {
+		var token string
+		token, rest = nextAuditToken(rest)
+
+		eq := strings.IndexByte(token, '=')
+		if eq <= 0 {
+			continue
+		}
+		key, value := token[:eq], unquoteAuditValue(token[eq+1:])
+
+		if key == "msg" && strings.HasPrefix(value, "audit(") {
+			header := strings.TrimSuffix(strings.TrimSuffix(value, ":"), ")")
+			if i := strings.LastIndexByte(header, ':'); i >= 0 {
+				if sequence, err := strconv.ParseUint(header[i+1:], 10, 64); err == nil {
+					fields["sequence"] = sequence
+				}
+			}
+			continue
+		}
+		// the user space records quote their own key=value components in msg
+		if key == "msg" && strings.Contains(value, "=") {
+			fields[key] = auditFields(value)
+			continue
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+// nextAuditToken returns the next token of the audit record up to a space outside of quotes
+func nextAuditToken(s string) (string, string) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ' ':
+			return s[:i], s[i:]
+		}
+	}
+	return s, ""
+}
+
+// unquoteAuditValue removes the double or single quotes around a value
+func unquoteAuditValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
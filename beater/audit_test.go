@@ -0,0 +1,101 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestAuditFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    common.MapStr
+	}{
+		{
+			name:    "kernel record",
+			message: `AVC apparmor="DENIED" operation="open" name="/etc/shadow" pid=42`,
+			want: common.MapStr{
+				"apparmor":  "DENIED",
+				"operation": "open",
+				"name":      "/etc/shadow",
+				"pid":       "42",
+			},
+		},
+		{
+			name:    "user space record",
+			message: `type=USER_LOGIN msg=audit(1500000000.123:12): pid=7 msg='op=login acct="root" res=success'`,
+			want: common.MapStr{
+				"type":     "USER_LOGIN",
+				"sequence": uint64(12),
+				"pid":      "7",
+				"msg":      common.MapStr{"op": "login", "acct": "root", "res": "success"},
+			},
+		},
+		{
+			name:    "quoted spaces",
+			message: `comm="my app" exe='/usr/bin/my app'`,
+			want:    common.MapStr{"comm": "my app", "exe": "/usr/bin/my app"},
+		},
+		{
+			name:    "words without a key",
+			message: `  some words =skipped key=value  `,
+			want:    common.MapStr{"key": "value"},
+		},
+		{
+			name:    "empty",
+			message: "",
+			want:    common.MapStr{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := auditFields(test.message); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMapStrFromJournalEntryParseAudit(t *testing.T) {
+	message := "type=1400 apparmor=\"DENIED\""
+	tests := []struct {
+		parse     bool
+		transport string
+		want      interface{}
+	}{
+		{true, auditTransport, common.MapStr{"type": "1400", "apparmor": "DENIED"}},
+		{true, "kernel", nil},
+		{false, auditTransport, nil},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.ParseAudit = test.parse
+		entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": message, "_TRANSPORT": test.transport}}
+
+		event := MapStrFromJournalEntry(entry, &cfg, nil)
+		if got, _ := event.GetValue("audit"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parse_audit %v, transport %s: got %v, want %v", test.parse, test.transport, got, test.want)
+		}
+		// the message is kept
+		if event["MESSAGE"] != message {
+			t.Errorf("parse_audit %v, transport %s: got message %v", test.parse, test.transport, event["MESSAGE"])
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// bootIDFile holds the ID of the current boot
+const bootIDFile = "/proc/sys/kernel/random/boot_id"
+
+// bootTerms returns the match of the boot selected by boot_offset if only a single boot is followed
+func (r *journalReader) bootTerms() ([]filterTerm, error) {
+	if !r.config.CurrentBootOnly {
+		return nil, nil
+	}
+
+	id, err := r.bootID(r.config.BootOffset)
+	if err != nil {
+		return nil, fmt.Errorf("Looking up the boot with offset %d failed: %v", r.config.BootOffset, err)
+	}
+	return []filterTerm{{sdjournal.SD_JOURNAL_FIELD_BOOT_ID + "=" + id}}, nil
+}
+
+// bootID returns the ID of the boot at the given offset like journalctl -b does:
+// 0 is the current boot, -1 the previous one and so on
+func (r *journalReader) bootID(offset int) (string, error) {
+	if offset == 0 {
+		id, err := ioutil.ReadFile(bootIDFile)
+		if err != nil {
+			return "", err
+		}
+		// the journal stores the ID without dashes
+		return strings.Replace(strings.TrimSpace(string(id)), "-", "", -1), nil
+	}
+
+	boots, err := listBoots(r.journal)
+	if err != nil {
+		return "", err
+	}
+
+	i := len(boots) - 1 + offset
+	if i < 0 || i >= len(boots) {
+		return "", fmt.Errorf("the journal only holds %d boots", len(boots))
+	}
+	return boots[i], nil
+}
+
+// listBoots returns the IDs of the boots in the journal, ordered by their first entry. It
+// seeks the journal and flushes its matches, so it has to be called before the filter is added.
+func listBoots(j *sdjournal.Journal) ([]string, error) {
+	ids, err := j.GetUniqueValues(sdjournal.SD_JOURNAL_FIELD_BOOT_ID)
+	if err != nil {
+		return nil, err
+	}
+	defer j.FlushMatches()
+
+	first := map[string]uint64{}
+	for _, id := range ids {
+		j.FlushMatches()
+		if err := j.AddMatch(sdjournal.SD_JOURNAL_FIELD_BOOT_ID + "=" + id); err != nil {
+			return nil, err
+		}
+		if err := j.SeekHead(); err != nil {
+			return nil, err
+		}
+		if n, err := j.Next(); err != nil || n == 0 {
+			continue
+		}
+		if first[id], err = j.GetRealtimeUsec(); err != nil {
+			return nil, err
+		}
+	}
+
+	boots := make([]string, 0, len(first))
+	for id := range first {
+		boots = append(boots, id)
+	}
+	sort.Slice(boots, func(a, b int) bool { return first[boots[a]] < first[boots[b]] })
+	return boots, nil
+}
+
+// checkBootBoundary publishes a boot boundary event if the boot of the entry differs from the
+// boot of the previous entry read from the same journal. The first entry read only sets the boot.
+func (jb *Journalbeat) checkBootBoundary(entry *sdjournal.JournalEntry, source *journalReader) {
+	id, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_BOOT_ID]
+	if !ok {
+		return
+	}
+
+	// the sources follow journals of their own
+	owner := jb.journalReader
+	if source != nil {
+		owner = source
+	}
+	previous := owner.lastBootID
+	owner.lastBootID = id
+	if previous == "" || previous == id {
+		return
+	}
+
+	event := common.MapStr{
+		"@timestamp": common.Time(entryTimestamp(entry, false)),
+		"type":       "boot_boundary",
+		"boot": common.MapStr{
+			"id":          id,
+			"previous_id": previous,
+		},
+		"cursor": entry.Cursor,
+	}
+	if source != nil {
+		_, _ = event.Put("journalbeat.source", source.name)
+	}
+	jb.client.PublishEvent(event)
+}
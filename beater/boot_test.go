@@ -0,0 +1,69 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestEventFromEntryBootMarkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		bootIDs []string
+		want    [][2]string
+	}{
+		{"same boot", true, []string{"b1", "b1", "b1"}, nil},
+		{"new boot", true, []string{"b1", "b1", "b2"}, [][2]string{{"b1", "b2"}}},
+		{"every boot", true, []string{"b1", "b2", "b3"}, [][2]string{{"b1", "b2"}, {"b2", "b3"}}},
+		// entries without a boot id do not reset the boot
+		{"missing boot id", true, []string{"b1", "", "b1"}, nil},
+		{"disabled", false, []string{"b1", "b2"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.EmitBootMarkers = test.enabled
+			jb, client := newTestBeat(cfg)
+
+			for _, id := range test.bootIDs {
+				fields := map[string]string{"MESSAGE": "hello"}
+				if id != "" {
+					fields[sdjournal.SD_JOURNAL_FIELD_BOOT_ID] = id
+				}
+				jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c-" + id, RealtimeTimestamp: 1, Fields: fields})
+			}
+
+			var got [][2]string
+			for _, event := range client.published() {
+				if event["type"] != "boot_boundary" {
+					continue
+				}
+				boot := event["boot"].(common.MapStr)
+				got = append(got, [2]string{boot["previous_id"].(string), boot["id"].(string)})
+				if event["cursor"] != "c-"+boot["id"].(string) {
+					t.Errorf("got cursor %v, want the cursor of the first entry of the boot", event["cursor"])
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got boot markers %v, want %v", got, test.want)
+			}
+		})
+	}
+}
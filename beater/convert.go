@@ -15,11 +15,22 @@
 package beater
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
-	"github.com/coreos/go-systemd/sdjournal"
+	"github.com/danwakefield/fnmatch"
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
 )
 
 // SyslogFacilityString is a map containing the textual equivalence of a given facility number
@@ -69,74 +80,596 @@ var PriorityConversionMap = map[string]string{
 // - remove underscores from the beginning of fields as they are reserved in
 //   ElasticSearch for metadata information
 // - fields that can be converted to numbers, will be converted to numbers
-func MapStrFromJournalEntry(ev *sdjournal.JournalEntry, cleanKeys bool, convertToNumbers bool, MoveMetadataLocation string, ParsePriority bool, ParseFacility bool) common.MapStr {
+// - fields other than the message that are boolean words, will be converted to booleans
+func MapStrFromJournalEntry(ev *sdjournal.JournalEntry, cfg *config.Config, sanitizer *strings.Replacer) common.MapStr {
 	m := common.MapStr{}
 	// for the sake of MoveMetadataLocation we will write all the JournalEntry data except the "message" here
 	target := m
 
+	// with metadata_flatten the fields stay on the top level, named after the location instead
+	prefix := ""
+	if cfg.MoveMetadataLocation != "" && cfg.MetadataFlatten {
+		prefix = cfg.MoveMetadataLocation + "."
+	}
+
 	// convert non-empty MoveMetadataLocation to the nested common.MapStr{} and point target to the deepest one
-	if MoveMetadataLocation != "" {
-		dests := strings.Split(MoveMetadataLocation, ".")
+	if cfg.MoveMetadataLocation != "" && !cfg.MetadataFlatten {
+		dests := strings.Split(cfg.MoveMetadataLocation, ".")
 		for _, key := range dests {
 			target[key] = common.MapStr{}
 			target = target[key].(common.MapStr)
 		}
 	}
 
+	// keys of the journal fields written to target
+	var keys []string
+	truncated := false
+	var message interface{}
+	var priorityLevel string
+
+	// names of the fields after cleaning and sanitizing
+	names := makeNewKeys(ev.Fields, cfg, sanitizer)
+
+	// the fields under their journal names next to the cleaned ones, by the key written to target
+	var originals common.MapStr
+	var originalOf map[string]string
+	if cfg.CleanFieldNames && cfg.KeepOriginalFields {
+		originals = common.MapStr{}
+		originalOf = map[string]string{}
+	}
+
 	// range over the JournalEntry Fields and convert to the common.MapStr
 	for k, v := range ev.Fields {
-		nk := makeNewKey(k, cleanKeys)
-		if nk == "priority" && ParsePriority {
-			v = PriorityConversionMap[v]
+		nk := names[k]
+		// include_fields goes first and never removes the message, drop_fields removes from the rest
+		if len(cfg.IncludeFields) > 0 && k != cfg.MessageField && !matchesField(cfg.IncludeFields, k, nk) {
+			continue
+		}
+		if len(cfg.DropFields) > 0 && matchesField(cfg.DropFields, k, nk) {
+			continue
+		}
+		// the data threshold applies to the whole FIELD=value data, libsystemd truncates
+		// the data to it only as a hint, so larger data is cut here
+		if cfg.MaxFieldBytes > 0 && len(k)+1+len(v) >= cfg.MaxFieldBytes {
+			truncated = true
+			if max := cfg.MaxFieldBytes - len(k) - 1; max >= 0 && len(v) > max {
+				// a multibyte character is never split
+				for max > 0 && !utf8.RuneStart(v[max]) {
+					max--
+				}
+				v = v[:max]
+			}
 		}
-		if nk == "syslog_facility" && ParseFacility {
+		if cfg.BinaryFieldHandling != config.BinaryFieldHandlingNone {
+			var ok bool
+			if v, ok = binaryValue(v, cfg.BinaryFieldHandling); !ok {
+				continue
+			}
+		}
+		// the level of the priority is set from the top level of the event, by default the
+		// numeric priority stays in place
+		if k == sdjournal.SD_JOURNAL_FIELD_PRIORITY && cfg.ParsePriority {
+			if name, ok := cfg.PriorityMap[strings.TrimSpace(v)]; ok {
+				priorityLevel = name
+			}
+			if !cfg.PriorityKeepRaw {
+				continue
+			}
+		}
+		if nk == "syslog_facility" && cfg.ParseSyslogFacility {
 			v = PriorityConversionMap[v]
 		}
-		nv := makeNewValue(v, convertToNumbers)
+		// the message is never converted to a boolean, a message reading "True" is still a message
+		convertToBooleans := cfg.ConvertToBooleans && k != cfg.MessageField
+		convertToNumbers := cfg.ConvertToNumbers && numberField(cfg, k, nk)
+		var nv interface{}
+		if values, ok := ev.MultiValueFields[k]; ok {
+			// a field appearing more than once in the entry keeps all of its values
+			nvs := make([]interface{}, 0, len(values))
+			for _, value := range values {
+				if cfg.BinaryFieldHandling != config.BinaryFieldHandlingNone {
+					var ok bool
+					if value, ok = binaryValue(value, cfg.BinaryFieldHandling); !ok {
+						continue
+					}
+				}
+				nvs = append(nvs, makeNewValue(value, convertToNumbers, convertToBooleans))
+			}
+			if len(nvs) == 0 {
+				continue
+			}
+			nv = nvs
+		} else {
+			nv = makeNewValue(v, convertToNumbers, convertToBooleans)
+		}
+		if originals != nil {
+			originals[k] = nv
+		}
 		// message Field should be on the top level of the event
-		if nk == "message" {
-			m[nk] = nv
+		if k == cfg.MessageField {
+			message = nv
+			continue
+		}
+		// mapped fields are placed at their dotted path from the top level of the event
+		if mapped, ok := mappedKey(k, nk, cfg.FieldMapping); ok {
+			_, _ = m.Put(mapped, nv)
 			continue
 		}
-		target[nk] = nv
+		target[prefix+nk] = nv
+		keys = append(keys, prefix+nk)
+		if originalOf != nil {
+			originalOf[prefix+nk] = k
+		}
+	}
+
+	// the message field is promoted after all other fields, so it wins over a cleaned
+	// MESSAGE field when the message is taken from another field. That MESSAGE is kept
+	// below the original fields key, where keep_original_fields keeps it anyway.
+	if message != nil {
+		messageKey := makeNewKey(sdjournal.SD_JOURNAL_FIELD_MESSAGE, cfg.CleanFieldNames)
+		if previous, ok := m[messageKey]; ok && cfg.MessageField != sdjournal.SD_JOURNAL_FIELD_MESSAGE && originals == nil {
+			_, _ = m.Put(cfg.OriginalFieldsKey+"."+sdjournal.SD_JOURNAL_FIELD_MESSAGE, previous)
+		}
+		m[messageKey] = message
+	}
+
+	if text, ok := message.(string); ok && cfg.DecodeJSONMessage {
+		decodeJSONMessage(m, text, makeNewKey(sdjournal.SD_JOURNAL_FIELD_MESSAGE, cfg.CleanFieldNames), &cfg.JSONMessage)
+	}
+
+	// the sequence number gives a strict order of the entries within a journal,
+	// which makes it a reliable tiebreaker when sorting by @timestamp. It is 0 if libsystemd
+	// could not read it.
+	if cfg.DecodeSeqnum && ev.Seqnum != 0 {
+		_, _ = m.Put("journalbeat.seqnum", int64(ev.Seqnum))
+	}
+
+	if cfg.DecodeCmdline {
+		if cmdline, ok := ev.Fields[sdjournal.SD_JOURNAL_FIELD_CMDLINE]; ok {
+			if args := splitCmdline(cmdline); len(args) > 0 {
+				_, _ = m.Put("process.command_line", strings.Join(args, " "))
+				_, _ = m.Put("process.args", args)
+				_, _ = m.Put("process.executable", args[0])
+			}
+		}
+	}
+
+	// the components of a kernel audit record are merged as structured fields
+	if cfg.ParseAudit && ev.Fields[sdjournal.SD_JOURNAL_FIELD_TRANSPORT] == auditTransport {
+		if text, ok := ev.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]; ok {
+			if fields := auditFields(text); len(fields) > 0 {
+				_, _ = m.Put("audit", fields)
+			}
+		}
+	}
+
+	// the fields whose data reached the data threshold of libsystemd, by their journal names
+	if len(ev.TruncatedFields) > 0 {
+		truncated = true
+		_, _ = m.Put("journalbeat.truncated_fields", ev.TruncatedFields)
+	}
+
+	if truncated {
+		_, _ = m.Put("journalbeat.truncated", true)
+	}
+
+	if priorityLevel != "" {
+		_, _ = m.Put(cfg.PriorityField, priorityLevel)
+	}
+
+	// a level word in the message is more specific than the priority, so it wins
+	if cfg.LevelFromMessage {
+		if text, ok := message.(string); ok {
+			if level, ok := levelFromMessage(text, cfg.LevelTokens); ok {
+				_, _ = m.Put(cfg.PriorityField, level)
+			}
+		}
+	}
+
+	// drop the excess journal fields to guard against mapping explosions, sorting
+	// the keys first makes sure the same fields are kept for the same entries
+	if cfg.MaxFieldsPerEvent > 0 && len(keys) > cfg.MaxFieldsPerEvent {
+		sort.Strings(keys)
+		for _, key := range keys[cfg.MaxFieldsPerEvent:] {
+			delete(target, key)
+			if originals != nil {
+				delete(originals, originalOf[key])
+			}
+		}
+		_, _ = m.Put("journalbeat.fields_truncated", len(keys)-cfg.MaxFieldsPerEvent)
+	}
+
+	if len(originals) > 0 {
+		_, _ = m.Put(cfg.OriginalFieldsKey, originals)
 	}
 
 	return m
 }
 
-func makeNewKey(key string, cleanKeys bool) string {
-	if !cleanKeys {
-		return key
+// entryTimestamp returns the time of the entry, taken in this order from
+//   - the source timestamp (_SOURCE_REALTIME_TIMESTAMP) if useSource is set, which the client
+//     took when it logged the entry and is more accurate for entries forwarded from other
+//     hosts or received late
+//   - the realtime timestamp journald took when it received the entry
+//   - the current time, for entries without a timestamp
+func entryTimestamp(entry *sdjournal.JournalEntry, useSource bool) time.Time {
+	if value, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_SOURCE_REALTIME_TIMESTAMP]; ok && useSource {
+		t, err := parseEpoch(value, config.TimestampUnitMicroseconds)
+		if err == nil {
+			return t
+		}
+		logp.Debug("journalbeat", "Invalid timestamp in %s: %v", sdjournal.SD_JOURNAL_FIELD_SOURCE_REALTIME_TIMESTAMP, err)
+	}
+	if entry.RealtimeTimestamp > 0 {
+		return time.Unix(0, int64(entry.RealtimeTimestamp)*1000)
+	}
+	return time.Now()
+}
+
+// parseEpoch parses an epoch timestamp in the given unit. Without a unit it is
+// guessed from the magnitude of the value. The full precision of the value is kept.
+func parseEpoch(value string, unit string) (time.Time, error) {
+	epoch, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	return strings.TrimLeft(strings.ToLower(key), "_")
+	if unit == "" {
+		magnitude := epoch
+		if magnitude < 0 {
+			magnitude = -magnitude
+		}
+		switch {
+		case magnitude < 1e11:
+			unit = config.TimestampUnitSeconds
+		case magnitude < 1e14:
+			unit = config.TimestampUnitMilliseconds
+		case magnitude < 1e17:
+			unit = config.TimestampUnitMicroseconds
+		default:
+			unit = config.TimestampUnitNanoseconds
+		}
+	}
+
+	switch unit {
+	case config.TimestampUnitSeconds:
+		return time.Unix(epoch, 0), nil
+	case config.TimestampUnitMilliseconds:
+		return time.Unix(epoch/1e3, (epoch%1e3)*int64(time.Millisecond)), nil
+	case config.TimestampUnitMicroseconds:
+		return time.Unix(epoch/1e6, (epoch%1e6)*int64(time.Microsecond)), nil
+	case config.TimestampUnitNanoseconds:
+		return time.Unix(0, epoch), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown timestamp unit: %s", unit)
 }
 
-func makeNewValue(value string, convertToNumbers bool) interface{} {
-	switch value {
-	// convert booleans if possible
-	// strconv.ParseBool is unfortunately too forgiving,
-	// we only want the hard words
-	case "true", "TRUE", "True":
-		return true
-	case "false", "FALSE", "False":
-		return false
+// levelFromMessage looks up the all-caps word the message starts with in the level tokens,
+// e.g. "ERROR: ...", "[WARN] ..." or "INFO ..."
+func levelFromMessage(message string, tokens map[string]string) (string, bool) {
+	message = strings.TrimLeft(message, " \t[")
+	end := strings.IndexFunc(message, func(r rune) bool { return r < 'A' || r > 'Z' })
+	if end == -1 {
+		end = len(message)
+	}
+
+	level, ok := tokens[message[:end]]
+	return level, ok
+}
+
+// binaryValue encodes a value which is not valid UTF-8 according to the binary field handling.
+// It returns false if the value is to be dropped.
+func binaryValue(value string, handling string) (string, bool) {
+	if utf8.ValidString(value) {
+		return value, true
+	}
+
+	switch handling {
+	case config.BinaryFieldHandlingBase64:
+		return base64.StdEncoding.EncodeToString([]byte(value)), true
+	case config.BinaryFieldHandlingHex:
+		return hex.EncodeToString([]byte(value)), true
+	case config.BinaryFieldHandlingDrop:
+		return "", false
+	}
+	return value, true
+}
+
+// decodeJSONMessage merges the JSON object logged as the message into the event, at the top
+// level without overwriting the fields of the entry or under the target key. Messages which
+// are no JSON object, too large or nested too deep are left untouched.
+func decodeJSONMessage(event common.MapStr, message, messageKey string, cfg *config.JSONMessageConfig) {
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") || (cfg.MaxBytes > 0 && len(message) > cfg.MaxBytes) {
+		return
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &obj); err != nil || (cfg.MaxDepth > 0 && jsonDepth(obj) > cfg.MaxDepth) {
+		if cfg.AddErrorKey {
+			_, _ = event.Put("json_error", true)
+		}
+		return
+	}
+
+	if !cfg.KeepMessage {
+		delete(event, messageKey)
+	}
+	if cfg.Target != "" {
+		_, _ = event.Put(cfg.Target, common.MapStr(obj))
+		return
+	}
+	for k, v := range obj {
+		if _, ok := event[k]; !ok {
+			event[k] = v
+		}
+	}
+}
+
+// jsonDepth returns the nesting depth of a decoded JSON value
+func jsonDepth(value interface{}) int {
+	depth := 0
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			if d := jsonDepth(child); d > depth {
+				depth = d
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if d := jsonDepth(child); d > depth {
+				depth = d
+			}
+		}
+	default:
+		return 0
+	}
+	return depth + 1
+}
+
+// ceeMessage rewrites the message of the event to the @cee: prefix followed by a JSON object of
+// the original message as msg and the given fields of the event, named by their dotted path
+func ceeMessage(event common.MapStr, fields []string, messageKey string) error {
+	cee := common.MapStr{}
+	if message, ok := event[messageKey]; ok {
+		cee["msg"] = message
+	}
+	for _, field := range fields {
+		if value, err := event.GetValue(field); err == nil {
+			cee[field] = value
+		}
+	}
+
+	data, err := json.Marshal(cee)
+	if err != nil {
+		return err
+	}
+	event[messageKey] = "@cee:" + string(data)
+	return nil
+}
+
+// truncateMessage cuts the message to at most max characters, not bytes, so a multibyte
+// character is never split, and marks the cut with the number of bytes dropped
+func truncateMessage(message string, max int) string {
+	chars := 0
+	for i := range message {
+		if chars == max {
+			return fmt.Sprintf("%s…[truncated %d bytes]", message[:i], len(message)-i)
+		}
+		chars++
+	}
+	return message
+}
+
+// gelfEvent reshapes the event to a GELF message. The message becomes the short message, the
+// host and the level are taken from _HOSTNAME and PRIORITY of the entry and the timestamp is
+// given in seconds. All other fields become additional fields, flattened to their dotted path
+// and prefixed with an underscore. @timestamp and type are kept for the publisher.
+func gelfEvent(event common.MapStr, entry *sdjournal.JournalEntry, timestamp time.Time, messageKey string) common.MapStr {
+	host := entry.Fields[sdjournal.SD_JOURNAL_FIELD_HOSTNAME]
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	gelf := common.MapStr{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": "",
+		"timestamp":     float64(timestamp.UnixNano()/int64(time.Microsecond)) / 1e6,
+		"@timestamp":    event["@timestamp"],
+		"type":          event["type"],
+	}
+	if message, ok := event[messageKey]; ok {
+		gelf["short_message"] = fmt.Sprint(message)
+	}
+	if priority, err := strconv.Atoi(strings.TrimSpace(entry.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY])); err == nil {
+		gelf["level"] = priority
+	}
+
+	for key, value := range event {
+		switch key {
+		case messageKey, "@timestamp", "type":
+			continue
+		}
+		addGELFFields(gelf, key, value)
+	}
+	return gelf
+}
+
+// addGELFFields adds the value as additional fields named after the key. Nested objects are
+// flattened, other values which are neither strings nor numbers are added as JSON. The
+// characters GELF does not allow in field names are replaced with underscores.
+func addGELFFields(gelf common.MapStr, key string, value interface{}) {
+	if nested, ok := value.(common.MapStr); ok {
+		for k, v := range nested {
+			addGELFFields(gelf, key+"."+k, v)
+		}
+		return
+	}
+
+	name := "_" + strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, key)
+	// _id is reserved by GELF
+	if name == "_id" {
+		name = "__id"
+	}
+
+	switch v := value.(type) {
+	case string, int, int64, uint64, float64:
+		gelf[name] = v
 	default:
-		if !convertToNumbers {
-			return value
+		if data, err := json.Marshal(v); err == nil {
+			gelf[name] = string(data)
+		}
+	}
+}
+
+// splitCmdline splits a command line into its arguments. The arguments are
+// separated by NULs when taken verbatim from the kernel, otherwise by spaces.
+func splitCmdline(cmdline string) []string {
+	if strings.ContainsRune(cmdline, 0) {
+		var args []string
+		for _, arg := range strings.Split(cmdline, "\x00") {
+			if arg != "" {
+				args = append(args, arg)
+			}
+		}
+		return args
+	}
+
+	return strings.Fields(cmdline)
+}
+
+// matchesField reports whether the journal name or the cleaned name of a field matches
+// any of the fnmatch patterns
+func matchesField(patterns []string, key, cleanKey string) bool {
+	for _, pattern := range patterns {
+		if fnmatch.Match(pattern, key, fnmatch.FNM_NOESCAPE) || fnmatch.Match(pattern, cleanKey, fnmatch.FNM_NOESCAPE) {
+			return true
+		}
+	}
+	return false
+}
+
+// numberField reports whether the field is converted to a number by convert_to_numbers. Without
+// number_fields every field is, number_exclude takes fields out either way.
+func numberField(cfg *config.Config, key, cleanKey string) bool {
+	if len(cfg.NumberFields) > 0 && !matchesField(cfg.NumberFields, key, cleanKey) {
+		return false
+	}
+	return !matchesField(cfg.NumberExclude, key, cleanKey)
+}
+
+// mappedKey looks up the field in the field mapping, by its journal name first and by
+// its cleaned name second
+func mappedKey(key, cleanKey string, mapping map[string]string) (string, bool) {
+	if mapped, ok := mapping[key]; ok {
+		return mapped, true
+	}
+	mapped, ok := mapping[cleanKey]
+	return mapped, ok
+}
+
+// newSanitizer returns the replacer of the characters of sanitize_keys, nil if there are none.
+// The longer characters are replaced first, so of overlapping ones like "." and ".." the longer
+// one wins whatever the order of the map.
+func newSanitizer(replacements map[string]string) *strings.Replacer {
+	if len(replacements) == 0 {
+		return nil
+	}
+
+	olds := make([]string, 0, len(replacements))
+	for old := range replacements {
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, k int) bool {
+		if len(olds[i]) != len(olds[k]) {
+			return len(olds[i]) > len(olds[k])
 		}
-		// convert to unsigned integers if that works
-		if ui, err := strconv.ParseUint(value, 10, 64); err == nil {
-			return ui
+		return olds[i] < olds[k]
+	})
+
+	oldnew := make([]string, 0, 2*len(olds))
+	for _, old := range olds {
+		oldnew = append(oldnew, old, replacements[old])
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+// makeNewKeys returns the new names of the fields. With a sanitizer the characters are
+// replaced after cleaning; names colliding after that get a numeric suffix, assigned in
+// the order of the field names so the same fields get the same names in every entry.
+func makeNewKeys(fields map[string]string, cfg *config.Config, sanitizer *strings.Replacer) map[string]string {
+	names := make(map[string]string, len(fields))
+	if sanitizer == nil {
+		for k := range fields {
+			names[k] = makeNewKey(k, cfg.CleanFieldNames)
 		}
-		// convert to signed integers if that works
-		if si, err := strconv.ParseInt(value, 10, 64); err == nil {
-			return si
+		return names
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	used := make(map[string]struct{}, len(fields))
+	for _, k := range keys {
+		nk := sanitizer.Replace(makeNewKey(k, cfg.CleanFieldNames))
+		if _, ok := used[nk]; ok {
+			for i := 2; ; i++ {
+				if _, ok := used[fmt.Sprintf("%s_%d", nk, i)]; !ok {
+					nk = fmt.Sprintf("%s_%d", nk, i)
+					break
+				}
+			}
 		}
-		// convert to float if that works
-		if fl, err := strconv.ParseFloat(value, 64); err == nil {
-			return fl
+		used[nk] = struct{}{}
+		names[k] = nk
+	}
+	return names
+}
+
+func makeNewKey(key string, cleanKeys bool) string {
+	if !cleanKeys {
+		return key
+	}
+
+	return strings.TrimLeft(strings.ToLower(key), "_")
+}
+
+func makeNewValue(value string, convertToNumbers bool, convertToBooleans bool) interface{} {
+	if convertToBooleans {
+		switch value {
+		// convert booleans if possible
+		// strconv.ParseBool is unfortunately too forgiving,
+		// we only want the hard words
+		case "true", "TRUE", "True":
+			return true
+		case "false", "FALSE", "False":
+			return false
 		}
+	}
+
+	if !convertToNumbers {
 		return value
 	}
+	// convert to unsigned integers if that works
+	if ui, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return ui
+	}
+	// convert to signed integers if that works
+	if si, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return si
+	}
+	// convert to float if that works
+	if fl, err := strconv.ParseFloat(value, 64); err == nil {
+		return fl
+	}
+	return value
 }
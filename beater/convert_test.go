@@ -0,0 +1,773 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		max     int
+		want    string
+	}{
+		{"hello", 5, "hello"},
+		{"hello", 10, "hello"},
+		{"hello world", 5, "hello…[truncated 6 bytes]"},
+		// characters, not bytes, are counted and a character is never split
+		{"héllo wörld", 5, "héllo…[truncated 7 bytes]"},
+		{"ééé", 1, "é…[truncated 4 bytes]"},
+		{"", 3, ""},
+	}
+	for _, test := range tests {
+		if got := truncateMessage(test.message, test.max); got != test.want {
+			t.Errorf("truncateMessage(%q, %d) = %q, want %q", test.message, test.max, got, test.want)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryMessageField(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		want   map[string]interface{}
+	}{
+		{"message", func(c *config.Config) {}, map[string]interface{}{
+			"MESSAGE":          "text",
+			"original.MESSAGE": nil,
+		}},
+		{"other field", func(c *config.Config) { c.MessageField = "SYSLOG_RAW" }, map[string]interface{}{
+			"MESSAGE":          "<13>raw text",
+			"original.MESSAGE": "text",
+		}},
+		{"other field with clean names", func(c *config.Config) {
+			c.MessageField = "SYSLOG_RAW"
+			c.CleanFieldNames = true
+		}, map[string]interface{}{
+			"message":          "<13>raw text",
+			"original.MESSAGE": "text",
+		}},
+		{"other field keeping the original fields", func(c *config.Config) {
+			c.MessageField = "SYSLOG_RAW"
+			c.CleanFieldNames = true
+			c.KeepOriginalFields = true
+		}, map[string]interface{}{
+			"message":             "<13>raw text",
+			"original.MESSAGE":    "text",
+			"original.SYSLOG_RAW": "<13>raw text",
+		}},
+		{"other field with moved metadata", func(c *config.Config) {
+			c.MessageField = "SYSLOG_RAW"
+			c.MoveMetadataLocation = "journal"
+		}, map[string]interface{}{
+			"MESSAGE":          "<13>raw text",
+			"journal.MESSAGE":  "text",
+			"original.MESSAGE": nil,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			test.modify(&cfg)
+			entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "text", "SYSLOG_RAW": "<13>raw text"}}
+
+			event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+			for key, want := range test.want {
+				got, err := event.GetValue(key)
+				if want == nil && err == nil {
+					t.Errorf("got %s %v, want none", key, got)
+				}
+				if want != nil && got != want {
+					t.Errorf("got %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMapStrFromJournalEntryKeepOriginalFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		want   map[string]interface{}
+	}{
+		{"clean names", func(c *config.Config) {
+			c.CleanFieldNames = true
+			c.KeepOriginalFields = true
+		}, map[string]interface{}{
+			"syslog_identifier":          "app",
+			"original.SYSLOG_IDENTIFIER": "app",
+			"original.MESSAGE":           "text",
+		}},
+		{"other key", func(c *config.Config) {
+			c.CleanFieldNames = true
+			c.KeepOriginalFields = true
+			c.OriginalFieldsKey = "journal.raw"
+		}, map[string]interface{}{
+			"journal.raw.SYSLOG_IDENTIFIER": "app",
+			"original":                      nil,
+		}},
+		// dropped fields are not kept either
+		{"dropped field", func(c *config.Config) {
+			c.CleanFieldNames = true
+			c.KeepOriginalFields = true
+			c.DropFields = []string{"SYSLOG_IDENTIFIER"}
+		}, map[string]interface{}{
+			"syslog_identifier":          nil,
+			"original.SYSLOG_IDENTIFIER": nil,
+			"original.MESSAGE":           "text",
+		}},
+		// without clean names the fields keep their journal names anyway
+		{"without clean names", func(c *config.Config) { c.KeepOriginalFields = true }, map[string]interface{}{
+			"SYSLOG_IDENTIFIER": "app",
+			"original":          nil,
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			test.modify(&cfg)
+			entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "text", "SYSLOG_IDENTIFIER": "app"}}
+
+			event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+			for key, want := range test.want {
+				got, err := event.GetValue(key)
+				if want == nil && err == nil {
+					t.Errorf("got %s %v, want none", key, got)
+				}
+				if want != nil && got != want {
+					t.Errorf("got %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMapStrFromJournalEntryParsePriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority string
+		modify   func(*config.Config)
+		want     map[string]interface{}
+	}{
+		{"disabled", "3", func(c *config.Config) {}, map[string]interface{}{
+			"PRIORITY":  "3",
+			"log.level": nil,
+		}},
+		{"default map", "3", func(c *config.Config) { c.ParsePriority = true }, map[string]interface{}{
+			"PRIORITY":  "3",
+			"log.level": "error",
+		}},
+		{"clean names", "6", func(c *config.Config) {
+			c.ParsePriority = true
+			c.CleanFieldNames = true
+		}, map[string]interface{}{
+			"priority":  "6",
+			"log.level": "info",
+		}},
+		{"overridden map", "4", func(c *config.Config) {
+			c.ParsePriority = true
+			c.PriorityMap = map[string]string{"4": "warn"}
+		}, map[string]interface{}{
+			"log.level": "warn",
+		}},
+		{"missing from the map", "9", func(c *config.Config) { c.ParsePriority = true }, map[string]interface{}{
+			"PRIORITY":  "9",
+			"log.level": nil,
+		}},
+		{"other field without the raw priority", "0", func(c *config.Config) {
+			c.ParsePriority = true
+			c.PriorityField = "severity"
+			c.PriorityKeepRaw = false
+		}, map[string]interface{}{
+			"PRIORITY":  nil,
+			"log.level": nil,
+			"severity":  "emergency",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.LevelFromMessage = false
+			test.modify(&cfg)
+			entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "text", "PRIORITY": test.priority}}
+
+			event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+			for key, want := range test.want {
+				got, err := event.GetValue(key)
+				if want == nil && err == nil {
+					t.Errorf("got %s %v, want none", key, got)
+				}
+				if want != nil && got != want {
+					t.Errorf("got %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMapStrFromJournalEntryLevelFromMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		modify  func(*config.Config)
+		want    map[string]interface{}
+	}{
+		{"level word", "WARN: disk almost full", func(c *config.Config) {}, map[string]interface{}{
+			"log.level": "warning",
+		}},
+		{"no level word", "disk almost full", func(c *config.Config) {}, map[string]interface{}{
+			"log.level": nil,
+		}},
+		{"priority field", "WARN: disk almost full", func(c *config.Config) { c.PriorityField = "severity" }, map[string]interface{}{
+			"severity":  "warning",
+			"log.level": nil,
+		}},
+		// the level word wins over the priority
+		{"with the priority", "WARN: disk almost full", func(c *config.Config) { c.ParsePriority = true }, map[string]interface{}{
+			"log.level": "warning",
+		}},
+		{"with the priority, no level word", "disk almost full", func(c *config.Config) { c.ParsePriority = true }, map[string]interface{}{
+			"log.level": "error",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.LevelFromMessage = true
+			test.modify(&cfg)
+			entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": test.message, "PRIORITY": "3"}}
+
+			event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+			for key, want := range test.want {
+				got, err := event.GetValue(key)
+				if want == nil && err == nil {
+					t.Errorf("got %s %v, want none", key, got)
+				}
+				if want != nil && got != want {
+					t.Errorf("got %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeNewValue(t *testing.T) {
+	tests := []struct {
+		value             string
+		numbers, booleans bool
+		want              interface{}
+	}{
+		{"True", false, true, true},
+		{"FALSE", false, true, false},
+		{"false", false, false, "false"},
+		// only the hard words are booleans
+		{"yes", false, true, "yes"},
+		{"1", false, true, "1"},
+		{"1", true, true, uint64(1)},
+		{"-1", true, false, int64(-1)},
+		{"1.5", true, false, 1.5},
+		{"1.5.1", true, false, "1.5.1"},
+	}
+	for _, test := range tests {
+		if got := makeNewValue(test.value, test.numbers, test.booleans); got != test.want {
+			t.Errorf("makeNewValue(%q, %v, %v) = %#v, want %#v", test.value, test.numbers, test.booleans, got, test.want)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryConvertsBooleansByDefault(t *testing.T) {
+	cfg := testConfig(t)
+	entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "true", "ENABLED": "true"}}
+
+	event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+	if event["ENABLED"] != true {
+		t.Errorf("got ENABLED %#v, want true", event["ENABLED"])
+	}
+	// the message is never converted
+	if event["MESSAGE"] != "true" {
+		t.Errorf("got MESSAGE %#v, want \"true\"", event["MESSAGE"])
+	}
+}
+
+func TestMakeNewKeysSanitizes(t *testing.T) {
+	cfg := testConfig(t)
+	tests := []struct {
+		name         string
+		replacements map[string]string
+		fields       []string
+		want         map[string]string
+	}{
+		{
+			name:   "no replacements",
+			fields: []string{"A.B"},
+			want:   map[string]string{"A.B": "A.B"},
+		},
+		{
+			name:         "overlapping replacements, the longer wins",
+			replacements: map[string]string{".": "_", "..": "-", "...": "+"},
+			fields:       []string{"A.B", "A..B", "A...B", "A....B"},
+			want:         map[string]string{"A.B": "A_B", "A..B": "A-B", "A...B": "A+B", "A....B": "A+_B"},
+		},
+		{
+			name:         "colliding names get a suffix in the order of the fields",
+			replacements: map[string]string{".": "_", "-": "_"},
+			fields:       []string{"A_B", "A.B", "A-B"},
+			want:         map[string]string{"A-B": "A_B", "A.B": "A_B_2", "A_B": "A_B_3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := make(map[string]string, len(test.fields))
+			for _, f := range test.fields {
+				fields[f] = "value"
+			}
+			sanitizer := newSanitizer(test.replacements)
+			// map order must not change the names
+			for i := 0; i < 20; i++ {
+				if got := makeNewKeys(fields, &cfg, sanitizer); !reflect.DeepEqual(got, test.want) {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMapStrFromJournalEntryConvertToBooleans(t *testing.T) {
+	tests := []struct {
+		convert bool
+		enabled interface{}
+	}{
+		{true, true},
+		{false, "True"},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.ConvertToBooleans = test.convert
+		entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "True", "ENABLED": "True"}}
+
+		event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+		if event["ENABLED"] != test.enabled {
+			t.Errorf("convert_to_booleans %v: got ENABLED %#v, want %#v", test.convert, event["ENABLED"], test.enabled)
+		}
+		if event["MESSAGE"] != "True" {
+			t.Errorf("convert_to_booleans %v: got MESSAGE %#v, want \"True\"", test.convert, event["MESSAGE"])
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryDecodeSeqnum(t *testing.T) {
+	tests := []struct {
+		decode bool
+		seqnum uint64
+		want   interface{}
+	}{
+		{true, 4711, int64(4711)},
+		// libsystemd older than 254 does not read the sequence number
+		{true, 0, nil},
+		{false, 4711, nil},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.DecodeSeqnum = test.decode
+		entry := &sdjournal.JournalEntry{Seqnum: test.seqnum, Fields: map[string]string{"MESSAGE": "hello"}}
+
+		event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+		got, _ := event.GetValue("journalbeat.seqnum")
+		if got != test.want {
+			t.Errorf("decode_seqnum %v, seqnum %d: got %#v, want %#v", test.decode, test.seqnum, got, test.want)
+		}
+	}
+}
+
+func TestSplitCmdline(t *testing.T) {
+	tests := []struct {
+		cmdline string
+		want    []string
+	}{
+		{"/usr/bin/sshd -D", []string{"/usr/bin/sshd", "-D"}},
+		{"  /usr/bin/sshd   -D ", []string{"/usr/bin/sshd", "-D"}},
+		// the kernel separates the arguments by NUL, arguments may contain spaces then
+		{"/bin/sh\x00-c\x00echo hello\x00", []string{"/bin/sh", "-c", "echo hello"}},
+		{"", nil},
+		{"\x00", nil},
+	}
+	for _, test := range tests {
+		if got := splitCmdline(test.cmdline); (len(got) != 0 || len(test.want) != 0) && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitCmdline(%q) = %q, want %q", test.cmdline, got, test.want)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryDecodeCmdline(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.DecodeCmdline = true
+	entry := &sdjournal.JournalEntry{Fields: map[string]string{
+		"MESSAGE":                          "hello",
+		sdjournal.SD_JOURNAL_FIELD_CMDLINE: "/bin/sh\x00-c\x00echo hello",
+	}}
+
+	event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+	want := common.MapStr{
+		"command_line": "/bin/sh -c echo hello",
+		"args":         []string{"/bin/sh", "-c", "echo hello"},
+		"executable":   "/bin/sh",
+	}
+	if got, _ := event.GetValue("process"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got process %v, want %v", got, want)
+	}
+}
+
+func TestMapStrFromJournalEntryMaxFieldBytes(t *testing.T) {
+	tests := []struct {
+		value     string
+		max       int
+		want      string
+		truncated bool
+	}{
+		{"hello", 0, "hello", false},
+		{"hello", 20, "hello", false},
+		// the limit counts MESSAGE= as well
+		{"hello world", 13, "hello", true},
+		// the value is cut back to the start of the character at the limit
+		{"héllo", 10, "h", true},
+		{"héllo", 11, "hé", true},
+		{"日本語", 12, "日", true},
+		{"日本語", 8, "", true},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.MaxFieldBytes = test.max
+		entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": test.value}}
+
+		event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+		got, _ := event["MESSAGE"].(string)
+		if got != test.want || !utf8.ValidString(got) {
+			t.Errorf("max_field_bytes %d, MESSAGE %q: got %q, want %q", test.max, test.value, got, test.want)
+		}
+		if truncated, _ := event.GetValue("journalbeat.truncated"); (truncated == true) != test.truncated {
+			t.Errorf("max_field_bytes %d, MESSAGE %q: got truncated %v, want %v", test.max, test.value, truncated, test.truncated)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryMaxFieldsPerEvent(t *testing.T) {
+	tests := []struct {
+		max       int
+		kept      []string
+		truncated interface{}
+	}{
+		{0, []string{"A", "B", "C", "D"}, nil},
+		{4, []string{"A", "B", "C", "D"}, nil},
+		// the first fields by name are kept, and always the message
+		{2, []string{"A", "B"}, 2},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.MaxFieldsPerEvent = test.max
+		entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello", "D": "d", "C": "c", "B": "b", "A": "a"}}
+
+		event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+		if event["MESSAGE"] != "hello" {
+			t.Errorf("max %d: the message was dropped", test.max)
+		}
+		var kept []string
+		for _, key := range []string{"A", "B", "C", "D"} {
+			if _, ok := event[key]; ok {
+				kept = append(kept, key)
+			}
+		}
+		if !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("max %d: got fields %v, want %v", test.max, kept, test.kept)
+		}
+		if truncated, _ := event.GetValue("journalbeat.fields_truncated"); truncated != test.truncated {
+			t.Errorf("max %d: got fields_truncated %#v, want %#v", test.max, truncated, test.truncated)
+		}
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	tests := []struct {
+		value string
+		unit  string
+		want  time.Time
+		err   bool
+	}{
+		{"1500000000", config.TimestampUnitSeconds, time.Unix(1500000000, 0), false},
+		{"1500000000123", config.TimestampUnitMilliseconds, time.Unix(1500000000, 123000000), false},
+		{"1500000000123456", config.TimestampUnitMicroseconds, time.Unix(1500000000, 123456000), false},
+		// the nanoseconds are kept
+		{"1500000000123456789", config.TimestampUnitNanoseconds, time.Unix(1500000000, 123456789), false},
+		{" 1500000000 ", config.TimestampUnitSeconds, time.Unix(1500000000, 0), false},
+		// without a unit it is guessed from the magnitude
+		{"1500000000", "", time.Unix(1500000000, 0), false},
+		{"1500000000123", "", time.Unix(1500000000, 123000000), false},
+		{"1500000000123456", "", time.Unix(1500000000, 123456000), false},
+		{"1500000000123456789", "", time.Unix(1500000000, 123456789), false},
+		{"-1500000000", "", time.Unix(-1500000000, 0), false},
+		{"1.5", config.TimestampUnitSeconds, time.Time{}, true},
+		{"", "", time.Time{}, true},
+		{"1500000000", "fortnights", time.Time{}, true},
+	}
+	for _, test := range tests {
+		got, err := parseEpoch(test.value, test.unit)
+		if (err != nil) != test.err {
+			t.Errorf("parseEpoch(%q, %q): got error %v, want an error: %v", test.value, test.unit, err, test.err)
+		}
+		if !got.Equal(test.want) {
+			t.Errorf("parseEpoch(%q, %q) = %v, want %v", test.value, test.unit, got, test.want)
+		}
+	}
+}
+
+func TestEntryTimestamp(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		realtime  uint64
+		useSource bool
+		want      time.Time
+	}{
+		{"source", "1500000000123456", 1600000000000000, true, time.Unix(1500000000, 123456000)},
+		{"source not used", "1500000000123456", 1600000000000000, false, time.Unix(1600000000, 0)},
+		{"invalid source", "soon", 1600000000000000, true, time.Unix(1600000000, 0)},
+		{"no source", "", 1600000000000000, true, time.Unix(1600000000, 0)},
+	}
+	for _, test := range tests {
+		entry := &sdjournal.JournalEntry{RealtimeTimestamp: test.realtime, Fields: map[string]string{"MESSAGE": "hello"}}
+		if test.source != "" {
+			entry.Fields[sdjournal.SD_JOURNAL_FIELD_SOURCE_REALTIME_TIMESTAMP] = test.source
+		}
+		if got := entryTimestamp(entry, test.useSource); !got.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// without any timestamp it is the time the entry is converted
+	before := time.Now()
+	if got := entryTimestamp(&sdjournal.JournalEntry{}, true); got.Before(before) || got.After(time.Now()) {
+		t.Errorf("got %v for an entry without a timestamp, want the current time", got)
+	}
+}
+
+func TestMapStrFromJournalEntryMultiValueFields(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.ConvertToNumbers = true
+	entry := &sdjournal.JournalEntry{
+		Fields:           map[string]string{"MESSAGE": "hello", "TAG": "b", "PORT": "443", "HOST": "a"},
+		MultiValueFields: map[string][]string{"TAG": {"a", "b"}, "PORT": {"80", "443"}},
+	}
+
+	event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+	tests := []struct {
+		field string
+		want  interface{}
+	}{
+		{"TAG", []interface{}{"a", "b"}},
+		// every value is converted on its own
+		{"PORT", []interface{}{uint64(80), uint64(443)}},
+		{"HOST", "a"},
+	}
+	for _, test := range tests {
+		if got := event[test.field]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("got %s %#v, want %#v", test.field, got, test.want)
+		}
+	}
+}
+
+func TestBinaryValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		handling string
+		want     string
+		keep     bool
+	}{
+		{"héllo", config.BinaryFieldHandlingBase64, "héllo", true},
+		{"héllo", config.BinaryFieldHandlingDrop, "héllo", true},
+		{"\xff\x00a", config.BinaryFieldHandlingNone, "\xff\x00a", true},
+		{"\xff\x00a", config.BinaryFieldHandlingBase64, "/wBh", true},
+		{"\xff\x00a", config.BinaryFieldHandlingHex, "ff0061", true},
+		{"\xff\x00a", config.BinaryFieldHandlingDrop, "", false},
+	}
+	for _, test := range tests {
+		got, keep := binaryValue(test.value, test.handling)
+		if got != test.want || keep != test.keep {
+			t.Errorf("binaryValue(%q, %s) = %q, %v, want %q, %v", test.value, test.handling, got, keep, test.want, test.keep)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryBinaryFieldHandling(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.BinaryFieldHandling = config.BinaryFieldHandlingDrop
+	entry := &sdjournal.JournalEntry{
+		Fields:           map[string]string{"MESSAGE": "\xffhello", "DATA": "\xff", "TAG": "b"},
+		MultiValueFields: map[string][]string{"TAG": {"\xfe", "b"}},
+	}
+
+	event := MapStrFromJournalEntry(entry, &cfg, newSanitizer(cfg.SanitizeKeys))
+	if _, ok := event["MESSAGE"]; ok {
+		t.Errorf("the binary message was kept: %q", event["MESSAGE"])
+	}
+	if _, ok := event["DATA"]; ok {
+		t.Errorf("the binary field was kept: %q", event["DATA"])
+	}
+	// of a multi-valued field only the binary values are dropped
+	if want := []interface{}{"b"}; !reflect.DeepEqual(event["TAG"], want) {
+		t.Errorf("got TAG %#v, want %#v", event["TAG"], want)
+	}
+}
+
+func TestMapStrFromJournalEntryMetadataFlatten(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		flatten  bool
+		want     common.MapStr
+	}{
+		{"top level", "", false, common.MapStr{"MESSAGE": "hello", "_PID": "1"}},
+		{"flatten without location", "", true, common.MapStr{"MESSAGE": "hello", "_PID": "1"}},
+		{"nested", "journal.fields", false, common.MapStr{
+			"MESSAGE": "hello",
+			"journal": common.MapStr{"fields": common.MapStr{"_PID": "1"}},
+		}},
+		{"flattened", "journal.fields", true, common.MapStr{"MESSAGE": "hello", "journal.fields._PID": "1"}},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.MoveMetadataLocation = test.location
+		cfg.MetadataFlatten = test.flatten
+		entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello", "_PID": "1"}}
+
+		if got := MapStrFromJournalEntry(entry, &cfg, nil); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMapStrFromJournalEntryNumberFields(t *testing.T) {
+	fields := map[string]string{"MESSAGE": "hello", "_PID": "0042", "CODE_LINE": "17", "VERSION": "1.5"}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    map[string]interface{}
+		clean   bool
+	}{
+		{"all fields", nil, nil, map[string]interface{}{"_PID": uint64(42), "CODE_LINE": uint64(17), "VERSION": 1.5}, false},
+		{"allowlist", []string{"CODE_*"}, nil, map[string]interface{}{"_PID": "0042", "CODE_LINE": uint64(17), "VERSION": "1.5"}, false},
+		{"excluded", nil, []string{"_PID", "VERSION"}, map[string]interface{}{"_PID": "0042", "CODE_LINE": uint64(17), "VERSION": "1.5"}, false},
+		{"exclusion wins", []string{"CODE_LINE", "_PID"}, []string{"_PID"}, map[string]interface{}{"_PID": "0042", "CODE_LINE": uint64(17), "VERSION": "1.5"}, false},
+		// the cleaned names match as well
+		{"cleaned name", []string{"pid"}, nil, map[string]interface{}{"pid": uint64(42), "code_line": "17", "version": "1.5"}, true},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.ConvertToNumbers = true
+		cfg.CleanFieldNames = test.clean
+		cfg.NumberFields = test.include
+		cfg.NumberExclude = test.exclude
+		entry := &sdjournal.JournalEntry{Fields: fields}
+
+		event := MapStrFromJournalEntry(entry, &cfg, nil)
+		for field, want := range test.want {
+			if got := event[field]; got != want {
+				t.Errorf("%s: got %s %#v, want %#v", test.name, field, got, want)
+			}
+		}
+	}
+}
+
+func TestGELFEvent(t *testing.T) {
+	timestamp := time.Unix(1500000000, 123456789)
+	entry := &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello", "PRIORITY": "3", "_HOSTNAME": "host1"}}
+	event := common.MapStr{
+		"@timestamp": common.Time(timestamp),
+		"type":       "journal",
+		"MESSAGE":    "hello",
+		"PRIORITY":   uint64(3),
+		"_PID":       "42",
+		"id":         "x",
+		"unit name":  "ssh.service",
+		"enabled":    true,
+		"journal":    common.MapStr{"fields": common.MapStr{"a": "b"}},
+	}
+
+	want := common.MapStr{
+		"version":       "1.1",
+		"host":          "host1",
+		"short_message": "hello",
+		// seconds with the microseconds of the journal as the fraction
+		"timestamp":         1500000000.123456,
+		"level":             3,
+		"@timestamp":        common.Time(timestamp),
+		"type":              "journal",
+		"_PRIORITY":         uint64(3),
+		"__PID":             "42",
+		"__id":              "x",
+		"_unit_name":        "ssh.service",
+		"_enabled":          "true",
+		"_journal.fields.a": "b",
+	}
+	if got := gelfEvent(event, entry, timestamp, "MESSAGE"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestEntryTimestampPrecedence(t *testing.T) {
+	source, realtime := time.Unix(1500000000, 123456000), time.Unix(1600000000, 654321000)
+	for _, sourceField := range []string{"valid", "invalid", "absent"} {
+		for _, hasRealtime := range []bool{true, false} {
+			for _, useSource := range []bool{true, false} {
+				entry := &sdjournal.JournalEntry{Fields: map[string]string{}}
+				switch sourceField {
+				case "valid":
+					entry.Fields[sdjournal.SD_JOURNAL_FIELD_SOURCE_REALTIME_TIMESTAMP] = "1500000000123456"
+				case "invalid":
+					entry.Fields[sdjournal.SD_JOURNAL_FIELD_SOURCE_REALTIME_TIMESTAMP] = "-"
+				}
+				if hasRealtime {
+					entry.RealtimeTimestamp = 1600000000654321
+				}
+
+				// source, then realtime, then now
+				before := time.Now()
+				got := entryTimestamp(entry, useSource)
+				switch {
+				case useSource && sourceField == "valid":
+					if !got.Equal(source) {
+						t.Errorf("source %s, realtime %v, use source %v: got %v, want the source timestamp", sourceField, hasRealtime, useSource, got)
+					}
+				case hasRealtime:
+					if !got.Equal(realtime) {
+						t.Errorf("source %s, realtime %v, use source %v: got %v, want the realtime timestamp", sourceField, hasRealtime, useSource, got)
+					}
+				default:
+					if got.Before(before) || got.After(time.Now()) {
+						t.Errorf("source %s, realtime %v, use source %v: got %v, want now", sourceField, hasRealtime, useSource, got)
+					}
+				}
+			}
+		}
+	}
+}
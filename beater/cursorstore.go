@@ -0,0 +1,166 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/outputs"
+	"github.com/mheese/journalbeat/config"
+)
+
+// httpCursorStoreTimeout bounds the requests to a remote cursor store
+const httpCursorStoreTimeout = 10 * time.Second
+
+// cursorStore persists the cursor of the last published event
+type cursorStore interface {
+	// Load returns the stored cursor
+	Load() (string, error)
+	// Save replaces the stored cursor
+	Save(cursor string) error
+	String() string
+}
+
+// newCursorStore returns the cursor store configured by cursor_store
+func newCursorStore(cfg *config.Config) (cursorStore, error) {
+	if cfg.CursorStore != config.CursorStoreHTTP {
+		return &fileCursorStore{file: cfg.CursorStateFile, durable: cfg.CursorFsync}, nil
+	}
+
+	// the ssl settings are the ones of the libbeat outputs
+	tlsConfig, err := outputs.LoadTLSConfig(cfg.CursorStoreSSL)
+	if err != nil {
+		return nil, fmt.Errorf("Loading the SSL settings of the cursor store failed: %v", err)
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if tlsConfig != nil {
+		u, err := url.Parse(cfg.CursorStoreURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cursor store url %s: %v", cfg.CursorStoreURL, err)
+		}
+		transport.TLSClientConfig = tlsConfig.BuildModuleConfig(u.Hostname())
+	}
+
+	return &httpCursorStore{
+		url:      cfg.CursorStoreURL,
+		username: cfg.CursorStoreUsername,
+		password: cfg.CursorStorePassword,
+		client:   &http.Client{Timeout: httpCursorStoreTimeout, Transport: transport},
+	}, nil
+}
+
+// fileCursorStore stores the cursor in a local file
+type fileCursorStore struct {
+	file    string
+	durable bool
+}
+
+func (s *fileCursorStore) Load() (string, error) {
+	cursor, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return "", err
+	}
+	return string(cursor), nil
+}
+
+func (s *fileCursorStore) Save(cursor string) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(s.file), fmt.Sprintf(".%s", filepath.Base(s.file)))
+	if err != nil {
+		return err
+	}
+
+	if _, err = tempFile.WriteString(cursor); err != nil {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	return replaceFile(tempFile, s.file, s.durable)
+}
+
+func (s *fileCursorStore) String() string {
+	return "cursor state file " + s.file
+}
+
+// httpCursorStore stores the cursor remotely, it is read with GET and written with PUT
+// on the same URL. It is meant for hosts without a writable local disk.
+type httpCursorStore struct {
+	url string
+	// username and password are sent with basic authentication if the username is set
+	username string
+	password string
+	client   *http.Client
+}
+
+// do sends the request with the credentials
+func (s *httpCursorStore) do(req *http.Request) (*http.Response, error) {
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return s.client.Do(req)
+}
+
+func (s *httpCursorStore) Load() (string, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	cursor, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(cursor)), nil
+}
+
+func (s *httpCursorStore) Save(cursor string) error {
+	req, err := http.NewRequest(http.MethodPut, s.url, strings.NewReader(cursor))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpCursorStore) String() string {
+	return "cursor store " + s.url
+}
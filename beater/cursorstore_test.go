@@ -0,0 +1,110 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/config"
+)
+
+// cursorServer is a remote cursor store accepting the user journalbeat with the password secret
+type cursorServer struct {
+	mu     sync.Mutex
+	cursor string
+}
+
+func (s *cursorServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if user, password, ok := r.BasicAuth(); !ok || user != "journalbeat" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		fmt.Fprint(w, s.cursor)
+	case http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		s.cursor = string(body)
+	}
+}
+
+func TestHTTPCursorStore(t *testing.T) {
+	server := httptest.NewTLSServer(&cursorServer{})
+	defer server.Close()
+
+	tmp, err := ioutil.TempDir("", "journalbeat-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	ca := filepath.Join(tmp, "ca.pem")
+	if err = ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"trusted CA", fmt.Sprintf("cursor_store_ssl.certificate_authorities: [%s]", ca), ""},
+		{"no verification", "cursor_store_ssl.verification_mode: none", ""},
+		{"unknown CA", "", "certificate"},
+		{"wrong password", fmt.Sprintf("cursor_store_ssl.certificate_authorities: [%s]\ncursor_store_password: wrong", ca), "401"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := common.NewConfigWithYAML([]byte(fmt.Sprintf("cursor_store: http\ncursor_store_url: %s\ncursor_store_username: journalbeat\ncursor_store_password: secret\n%s", server.URL, test.yaml)), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := config.DefaultConfig
+			if err = raw.Unpack(&cfg); err != nil {
+				t.Fatal(err)
+			}
+			store, err := newCursorStore(&cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Save("s=1;i=2")
+			if test.want != "" {
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("got %v, want an error containing %q", err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cursor, err := store.Load(); err != nil || cursor != "s=1;i=2" {
+				t.Errorf("got cursor %q, %v, want s=1;i=2", cursor, err)
+			}
+		})
+	}
+}
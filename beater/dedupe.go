@@ -0,0 +1,50 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"sync"
+)
+
+// cursorSet is a set of cursors which is safe for concurrent use. It holds the cursors of
+// the events republished from the pending queue until they are acked, so the same entries
+// read again from the journal are not published twice.
+type cursorSet struct {
+	sync.Mutex
+	cursors map[string]struct{}
+}
+
+func newCursorSet() *cursorSet {
+	return &cursorSet{cursors: map[string]struct{}{}}
+}
+
+func (s *cursorSet) add(cursor string) {
+	s.Lock()
+	defer s.Unlock()
+	s.cursors[cursor] = struct{}{}
+}
+
+func (s *cursorSet) remove(cursor string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.cursors, cursor)
+}
+
+func (s *cursorSet) contains(cursor string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.cursors[cursor]
+	return ok
+}
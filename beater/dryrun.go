@@ -0,0 +1,64 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/publisher"
+)
+
+var dryRun = flag.Bool("dryrun", false, "Print the events as JSON instead of publishing them")
+
+// dryRunClient implements the publisher.Client interface by printing the events as
+// JSON, one per line. The events are acked right away.
+type dryRunClient struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newDryRunClient(w io.Writer) *dryRunClient {
+	return &dryRunClient{enc: json.NewEncoder(w)}
+}
+
+func (c *dryRunClient) Close() error {
+	return nil
+}
+
+func (c *dryRunClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c *dryRunClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	_, ctx := publisher.MakeContext(opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range events {
+		if err := c.enc.Encode(event); err != nil {
+			logp.Err("Could not print the event: %v", err)
+			op.SigFailed(ctx.Signal, err)
+			return false
+		}
+	}
+	op.SigCompleted(ctx.Signal)
+	return true
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/publisher"
+	"github.com/mheese/journalbeat/config"
+)
+
+// fileOutputClient implements the publisher.Client interface by writing the events as JSON,
+// one per line, to a local file. The file is rotated once it reaches the max size, keeping
+// up to max files rotated files named like the file suffixed with .1, .2 and so on.
+// The events are acked once they are written, so the cursor and the pending queue follow
+// the file like they follow any other output.
+type fileOutputClient struct {
+	mu      sync.Mutex
+	cfg     config.FileOutput
+	durable bool
+	file    *os.File
+	size    int64
+}
+
+func newFileOutputClient(cfg config.FileOutput, durable bool) (*fileOutputClient, error) {
+	c := &fileOutputClient{cfg: cfg, durable: durable}
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open opens the file for appending
+func (c *fileOutputClient) open() error {
+	file, err := os.OpenFile(c.cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	c.file, c.size = file, info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files, drops the oldest one and starts a new file
+func (c *fileOutputClient) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+
+	if c.cfg.MaxFiles == 0 {
+		if err := os.Remove(c.cfg.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return c.open()
+	}
+
+	for i := c.cfg.MaxFiles; i > 1; i-- {
+		if err := os.Rename(rotatedFile(c.cfg.Path, i-1), rotatedFile(c.cfg.Path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(c.cfg.Path, rotatedFile(c.cfg.Path, 1)); err != nil {
+		return err
+	}
+	return c.open()
+}
+
+func (c *fileOutputClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Close()
+}
+
+func (c *fileOutputClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c *fileOutputClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	_, ctx := publisher.MakeContext(opts)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, event := range events {
+		if err := c.write(event); err != nil {
+			logp.Err("Could not write the event to the file output %s: %v", c.cfg.Path, err)
+			op.SigFailed(ctx.Signal, err)
+			return false
+		}
+	}
+	if c.durable {
+		if err := c.file.Sync(); err != nil {
+			logp.Err("Could not sync the file output %s: %v", c.cfg.Path, err)
+			op.SigFailed(ctx.Signal, err)
+			return false
+		}
+	}
+	op.SigCompleted(ctx.Signal)
+	return true
+}
+
+// write writes the event as one line, rotating the file first if the line does not fit anymore
+func (c *fileOutputClient) write(event common.MapStr) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if c.cfg.MaxSize > 0 && c.size > 0 && c.size+int64(len(line)) > c.cfg.MaxSize {
+		if err = c.rotate(); err != nil {
+			return fmt.Errorf("rotating failed: %v", err)
+		}
+	}
+
+	n, err := c.file.Write(line)
+	c.size += int64(n)
+	return err
+}
+
+// rotatedFile returns the name of the i-th rotated file
+func rotatedFile(file string, i int) string {
+	return fmt.Sprintf("%s.%d", file, i)
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// maxCommLength is the length the kernel truncates the command name of a process to
+const maxCommLength = 15
+
+// dropEntry decides whether a journal entry is dropped after it was read. It covers the
+// filters that can not be expressed as journal matches.
+func (jb *Journalbeat) dropEntry(entry *sdjournal.JournalEntry) bool {
+	if len(jb.excludedPriorities) > 0 {
+		if priority, err := strconv.Atoi(strings.TrimSpace(entry.Fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY])); err == nil {
+			if _, ok := jb.excludedPriorities[priority]; ok {
+				return true
+			}
+		}
+	}
+
+	// the own log lines of journalbeat would be read again and again
+	if jb.selfPID != "" && (entry.Fields[sdjournal.SD_JOURNAL_FIELD_PID] == jb.selfPID || entry.Fields[sdjournal.SD_JOURNAL_FIELD_COMM] == jb.selfComm) {
+		return true
+	}
+
+	if len(jb.unitPatterns) > 0 {
+		unit := entry.Fields[sdjournal.SD_JOURNAL_FIELD_SYSTEMD_UNIT]
+		matched := false
+		for _, pattern := range jb.unitPatterns {
+			if pattern.MatchString(unit) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// selfProcess returns the PID and the command name of journalbeat as they appear in the _PID and
+// _COMM fields of its own journal entries. The command name is truncated by the kernel.
+func selfProcess() (string, string) {
+	comm := filepath.Base(os.Args[0])
+	if data, err := ioutil.ReadFile("/proc/self/comm"); err == nil {
+		comm = strings.TrimSpace(string(data))
+	} else if len(comm) > maxCommLength {
+		comm = comm[:maxCommLength]
+	}
+	return strconv.Itoa(os.Getpid()), comm
+}
+
+// compileUnitPatterns compiles the unit patterns, which have to match the whole unit name
+func compileUnitPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Unit Pattern %s: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
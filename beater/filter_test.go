@@ -0,0 +1,87 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func priorityEntry(priority string) *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello", "PRIORITY": priority}}
+}
+
+func TestDropEntryExcludedPriorities(t *testing.T) {
+	jb, _ := newTestBeat(testConfig(t))
+	jb.excludedPriorities = map[int]struct{}{6: {}, 7: {}}
+
+	tests := []struct {
+		entry *sdjournal.JournalEntry
+		want  bool
+	}{
+		{priorityEntry("7"), true},
+		{priorityEntry(" 6 "), true},
+		{priorityEntry("3"), false},
+		// entries without a valid priority are kept
+		{priorityEntry("debug"), false},
+		{&sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": "hello"}}, false},
+	}
+	for _, test := range tests {
+		if got := jb.dropEntry(test.entry); got != test.want {
+			t.Errorf("PRIORITY %q: got drop %v, want %v", test.entry.Fields["PRIORITY"], got, test.want)
+		}
+	}
+}
+
+func TestDropEntrySelf(t *testing.T) {
+	jb, _ := newTestBeat(testConfig(t))
+	jb.selfPID, jb.selfComm = "4711", "journalbeat"
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   bool
+	}{
+		{"own PID", map[string]string{"_PID": "4711", "_COMM": "sh"}, true},
+		// a restarted journalbeat has another PID, but the same command
+		{"own command", map[string]string{"_PID": "1", "_COMM": "journalbeat"}, true},
+		{"other process", map[string]string{"_PID": "1", "_COMM": "systemd"}, false},
+		{"kernel", map[string]string{"_TRANSPORT": "kernel"}, false},
+	}
+	for _, test := range tests {
+		if got := jb.dropEntry(&sdjournal.JournalEntry{Fields: test.fields}); got != test.want {
+			t.Errorf("%s: got drop %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// without exclude_self nothing is dropped
+	jb.selfPID, jb.selfComm = "", ""
+	if jb.dropEntry(&sdjournal.JournalEntry{Fields: map[string]string{"_PID": "4711", "_COMM": "journalbeat"}}) {
+		t.Error("an entry was dropped without exclude_self")
+	}
+}
+
+func TestSelfProcess(t *testing.T) {
+	pid, comm := selfProcess()
+	if pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("got PID %s, want %d", pid, os.Getpid())
+	}
+	if comm == "" || len(comm) > maxCommLength {
+		t.Errorf("got command %q, want a command of at most %d characters", comm, maxCommLength)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	// forwardQueueSize is the number of lines queued for the forward socket
+	forwardQueueSize = 1024
+	// forwardWriteTimeout bounds writing a line, a stuck reader counts as a failed write
+	forwardWriteTimeout = time.Second
+	// forwardRedialPeriod is the least time between two attempts to connect to the socket
+	forwardRedialPeriod = 5 * time.Second
+)
+
+// socketForwarder writes the events as JSON, one per line, to a Unix domain socket in parallel
+// with the output. It never blocks publishing: the lines are queued and dropped if the queue is
+// full or the socket can not be written, in which case it reconnects.
+type socketForwarder struct {
+	path  string
+	lines chan []byte
+	done  chan struct{}
+
+	// conn and lastDial are only used by the write loop once it started
+	conn     net.Conn
+	lastDial time.Time
+}
+
+// newSocketForwarder connects to the socket and starts writing to it. A socket which can not be
+// connected to yet is connected to later.
+func newSocketForwarder(path string) *socketForwarder {
+	f := &socketForwarder{
+		path:  path,
+		lines: make(chan []byte, forwardQueueSize),
+		done:  make(chan struct{}),
+	}
+	if err := f.dial(); err != nil {
+		logp.Warn("Could not connect to the forward socket %s: %v", path, err)
+	}
+	go f.writeLoop()
+	return f
+}
+
+// dial connects to the socket
+func (f *socketForwarder) dial() error {
+	f.lastDial = time.Now()
+	conn, err := net.Dial("unix", f.path)
+	if err != nil {
+		return err
+	}
+	f.conn = conn
+	return nil
+}
+
+// forward queues the event. It is encoded right away, so the event may be changed afterwards.
+func (f *socketForwarder) forward(event common.MapStr) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		logp.Debug("journalbeat", "Could not encode the event for the forward socket: %v", err)
+		forwardDropped.Inc()
+		return
+	}
+
+	select {
+	case f.lines <- append(line, '\n'):
+	default:
+		forwardDropped.Inc()
+	}
+}
+
+// writeLoop writes the queued lines until the forwarder is closed
+func (f *socketForwarder) writeLoop() {
+	defer close(f.done)
+	for line := range f.lines {
+		if f.conn == nil {
+			if time.Since(f.lastDial) < forwardRedialPeriod {
+				forwardDropped.Inc()
+				continue
+			}
+			if err := f.dial(); err != nil {
+				logp.Debug("journalbeat", "Could not connect to the forward socket %s: %v", f.path, err)
+				forwardDropped.Inc()
+				continue
+			}
+			logp.Info("Connected to the forward socket %s", f.path)
+		}
+
+		_ = f.conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout))
+		if _, err := f.conn.Write(line); err != nil {
+			logp.Warn("Writing to the forward socket %s failed, reconnecting: %v", f.path, err)
+			_ = f.conn.Close()
+			f.conn = nil
+			forwardDropped.Inc()
+		}
+	}
+
+	if f.conn != nil {
+		_ = f.conn.Close()
+	}
+}
+
+// close writes the queued lines and closes the socket. No event may be forwarded afterwards.
+func (f *socketForwarder) close() {
+	close(f.lines)
+	<-f.done
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+// osReleaseFile describes the operating system, see os-release(5)
+const osReleaseFile = "/etc/os-release"
+
+// hostMetadata gathers the facts of the host like the add_host_metadata processor of the
+// newer beats does. It is gathered once at start up.
+func hostMetadata() common.MapStr {
+	host := common.MapStr{
+		"architecture": runtime.GOARCH,
+	}
+	if name, err := os.Hostname(); err == nil {
+		host["name"] = name
+	} else {
+		logp.Warn("Could not get the hostname: %v", err)
+	}
+
+	if release, err := readOSRelease(osReleaseFile); err == nil {
+		hostOS := common.MapStr{"kernel_type": runtime.GOOS}
+		for key, field := range map[string]string{"ID": "family", "NAME": "name", "VERSION": "version", "VERSION_ID": "version_id", "PRETTY_NAME": "full"} {
+			if value, ok := release[key]; ok {
+				hostOS[field] = value
+			}
+		}
+		host["os"] = hostOS
+	} else {
+		logp.Warn("Could not read %s: %v", osReleaseFile, err)
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		var ips []string
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+				ips = append(ips, ipnet.IP.String())
+			}
+		}
+		if len(ips) > 0 {
+			host["ip"] = ips
+		}
+	} else {
+		logp.Warn("Could not get the IP addresses of the host: %v", err)
+	}
+
+	return host
+}
+
+// readOSRelease reads the KEY=value lines of the os-release file, unquoting the values
+func readOSRelease(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	release := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		eq := strings.IndexByte(line, '=')
+		if line == "" || line[0] == '#' || eq <= 0 {
+			continue
+		}
+		value := line[eq+1:]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		release[line[:eq]] = value
+	}
+	return release, scanner.Err()
+}
+
+// addHostMetadata adds the host metadata under host, keeping the fields already there
+func addHostMetadata(event common.MapStr, metadata common.MapStr) {
+	host, ok := event["host"].(common.MapStr)
+	if !ok {
+		if _, exists := event["host"]; exists {
+			return
+		}
+		event["host"] = metadata.Clone()
+		return
+	}
+	for k, v := range metadata.Clone() {
+		if _, exists := host[k]; !exists {
+			host[k] = v
+		}
+	}
+}
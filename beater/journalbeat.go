@@ -15,170 +15,486 @@
 package beater
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/coreos/go-systemd/sdjournal"
 	"github.com/elastic/beats/libbeat/beat"
 	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
 	"github.com/elastic/beats/libbeat/logp"
 	"github.com/elastic/beats/libbeat/publisher"
 	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/fileinput"
 	"github.com/mheese/journalbeat/journal"
+	"github.com/mheese/journalbeat/journal/sdjournal"
 )
 
+// followJournal follows a journal like journal.Follow, the tests follow scripted entries instead
+var followJournal = journal.Follow
+
 // Journalbeat is the main Journalbeat struct
 type Journalbeat struct {
-	done   chan struct{}
-	config config.Config
-	client publisher.Client
+	done chan struct{}
+	// draining is closed by Stop while it waits for the pending events to be acked
+	draining chan struct{}
+	config   config.Config
+	client   publisher.Client
+
+	// the reader of the main journal
+	*journalReader
+	// reload hands the configuration reloaded on SIGHUP over to the follower of the journal
+	reload chan config.Config
+
+	excludedPriorities map[int]struct{}
+	unitPatterns       []*regexp.Regexp
+	catalog            *journal.Catalog
+	contextLines       *journal.ContextLines
+	hostMetadata       common.MapStr
+	sanitizer          *strings.Replacer
+	forwarder          *socketForwarder
+	rateLimiter        *rateLimiter
+	stopBound          *stopBound
+	stopOnce           sync.Once
+
+	// selfPID and selfComm identify the own entries of journalbeat if they are excluded
+	selfPID, selfComm string
 
-	journal *sdjournal.Journal
+	// cursors of the events republished from the pending queue and not acked yet
+	republished *cursorSet
 
-	cursorChan         chan string
-	pending, completed chan *eventReference
-	wg                 sync.WaitGroup
+	// state of the event loop in Run
+	syntheticKeys   uint64
+	embedStatsCount int
+	lastCursor      string
+	publishedMu     sync.Mutex
+
+	// sources are the readers of the additional journal sources
+	sources []*journalReader
+
+	pending    chan *eventReference
+	completed  *completedQueue
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+	fileInputs sync.WaitGroup
+	reporters  sync.WaitGroup
 }
 
-func (jb *Journalbeat) initJournal() error {
+func (r *journalReader) initJournal() error {
 	var err error
 
 	seekToHelper := func(position string, err error) error {
 		if err == nil {
 			logp.Info("Seek to %s successful", position)
 		} else {
+			seekFailures.Inc()
 			logp.Warn("Could not seek to %s: %v", position, err)
 		}
 		return err
 	}
 
+	if r.config.VerifyFSS {
+		if err = r.verifyJournal(); err != nil {
+			return err
+		}
+	}
+
+	if err = r.openJournal(); err != nil {
+		return err
+	}
+
+	// seek position
+	position := r.config.SeekPosition
+	// try seekToCursor first, if that is requested
+	if position == config.SeekPositionCursor {
+		// the rotated and the backup cursor files are only consulted if the cursor store is unreadable or corrupt
+		cursorStores := []cursorStore{r.cursorStore}
+		for i := 1; i <= r.config.CursorStateBackups; i++ {
+			cursorStores = append(cursorStores, &fileCursorStore{file: cursorStateBackup(r.config.CursorStateFile, i)})
+		}
+		if r.config.CursorBackupFile != "" {
+			cursorStores = append(cursorStores, &fileCursorStore{file: r.config.CursorBackupFile})
+		}
+
+		for _, store := range cursorStores {
+			// try to seek to cursor and if successful return
+			if err = seekToHelper(config.SeekPositionCursor, r.seekToStoredCursor(store)); err == nil {
+				if r.config.CursorMaxAge > 0 {
+					return r.capCursorAge(r.config.CursorMaxAge)
+				}
+				return nil
+			}
+		}
+
+		if r.config.CursorSeekFallback == config.SeekPositionDefault {
+			return err
+		}
+
+		position = r.config.CursorSeekFallback
+	}
+
+	switch position {
+	case config.SeekPositionHead:
+		err = seekToHelper(config.SeekPositionHead, r.seekHead(r.config.HeadSkip))
+	case config.SeekPositionTail:
+		r.startUsec = realtimeUsec(time.Now())
+		err = seekToHelper(config.SeekPositionTail, r.seekTail(r.config.TailEntries))
+	case config.SeekPositionSince:
+		since := time.Now().Add(r.config.SeekTime)
+		r.startUsec = realtimeUsec(since)
+		err = seekToHelper(fmt.Sprintf("%s %v", config.SeekPositionSince, since), r.journal.SeekRealtimeUsec(r.startUsec))
+	case config.SeekPositionRealtimeNow:
+		// unlike the tail, no entry appended before the start is read
+		now := time.Now()
+		r.startUsec = realtimeUsec(now)
+		err = seekToHelper(fmt.Sprintf("%s %v", config.SeekPositionRealtimeNow, now), r.journal.SeekRealtimeUsec(r.startUsec))
+	}
+
+	if err != nil {
+		return fmt.Errorf("Seeking to a good position in journal failed: %v", err)
+	}
+
+	return nil
+}
+
+// seekHead seeks to the head of the journal and skips n entries, so following starts with
+// the entry after the first n.
+func (r *journalReader) seekHead(n uint64) error {
+	if err := r.journal.SeekHead(); err != nil || n == 0 {
+		return err
+	}
+
+	_, err := r.journal.NextSkip(n)
+	return err
+}
+
+// seekTail seeks to the tail of the journal and moves back by n entries, so following starts
+// with the n-th entry from the tail. A journal with n entries or less is read from its head.
+func (r *journalReader) seekTail(n uint64) error {
+	if err := r.journal.SeekTail(); err != nil || n == 0 {
+		return err
+	}
+
+	// following moves to the next entry first, so the pointer goes back one entry more
+	skipped, err := r.journal.PreviousSkip(n + 1)
+	if err != nil {
+		return err
+	}
+	if skipped < n+1 {
+		return r.journal.SeekHead()
+	}
+	return nil
+}
+
+// openJournal connects to the journal and adds the filter
+func (r *journalReader) openJournal() error {
+	paths, err := journal.ExpandPaths(r.config.JournalPaths)
+	if err != nil {
+		return err
+	}
+
 	// connect to the Systemd Journal
-	switch len(jb.config.JournalPaths) {
-	case 0:
-		if jb.journal, err = sdjournal.NewJournal(); err != nil {
+	switch {
+	case r.config.JournalNamespace != "" || r.config.NamespaceMode == config.NamespaceModeAll:
+		if r.journal, err = journal.OpenNamespace(r.config.JournalNamespace, r.config.NamespaceMode, r.config.JournalScope); err != nil {
+			return err
+		}
+	case len(paths) == 0:
+		if r.journal, err = journal.Open(r.config.JournalScope); err != nil {
 			return err
 		}
-	case 1:
-		fi, err := os.Stat(jb.config.JournalPaths[0])
+	case len(paths) == 1:
+		if err = checkJournalPath(paths[0]); err != nil {
+			return err
+		}
+		fi, err := os.Stat(paths[0])
 		if err != nil {
 			return err
 		}
 		if fi.IsDir() {
-			if jb.journal, err = sdjournal.NewJournalFromDir(jb.config.JournalPaths[0]); err != nil {
+			if r.journal, err = sdjournal.NewJournalFromDir(paths[0]); err != nil {
 				return err
 			}
 		} else {
-			if jb.journal, err = sdjournal.NewJournalFromFiles(jb.config.JournalPaths...); err != nil {
+			if r.journal, err = sdjournal.NewJournalFromFiles(paths...); err != nil {
 				return err
 			}
 		}
 	default:
-		if jb.journal, err = sdjournal.NewJournalFromFiles(jb.config.JournalPaths...); err != nil {
+		for _, path := range paths {
+			if err = checkJournalPath(path); err != nil {
+				return err
+			}
+		}
+		if r.journal, err = sdjournal.NewJournalFromFiles(paths...); err != nil {
+			return err
+		}
+	}
+
+	if r.config.MaxFieldBytes > 0 {
+		if err = r.journal.SetDataThreshold(uint64(r.config.MaxFieldBytes)); err != nil {
 			return err
 		}
 	}
 
-	// add specific units to monitor if any
-	if err = jb.addUnits(); err != nil {
+	// add the units, kernel, syslog identifiers and patterns to monitor if any
+	groups, err := r.filterGroups()
+	if err != nil {
 		return err
 	}
+	if err = addFilter(r.journal, groups); err != nil {
+		return fmt.Errorf("Adding the journal filter failed: %v", err)
+	}
 
-	// add specific patterns to monitor if any
-	for _, pattern := range jb.config.MatchPatterns {
-		err = jb.journal.AddMatch(pattern)
-		if err == nil {
-			err = jb.journal.AddDisjunction()
-		}
+	return nil
+}
 
-		if err != nil {
-			return fmt.Errorf("Filtering pattern %s failed: %v", pattern, err)
-		}
+// verifyJournal logs whether the journal files are sealed and verifies them. A failed verification
+// only stops Journalbeat if the fss failure mode asks for it.
+func (r *journalReader) verifyJournal() error {
+	paths, err := journal.ExpandPaths(r.config.JournalPaths)
+	if err != nil {
+		return err
 	}
-
-	// add kernel logs
-	if err = jb.addKernel(); err != nil {
+	if len(paths) == 0 {
+		paths = journal.DefaultJournalDirs
+	}
+	files, err := journal.JournalFiles(paths)
+	if err != nil {
 		return err
 	}
 
-	// add syslog identifiers to monitor if any
-	if err = jb.addSyslogIdentifiers(); err != nil {
-		return err
+	sealed := 0
+	for _, file := range files {
+		if ok, err := journal.Sealed(file); err != nil {
+			logp.Warn("Could not check whether the journal file is sealed: %v", err)
+		} else if ok {
+			sealed++
+		}
 	}
+	logp.Info("%d of %d journal files are sealed", sealed, len(files))
 
-	// seek position
-	position := jb.config.SeekPosition
-	// try seekToCursor first, if that is requested
-	if position == config.SeekPositionCursor {
-		if cursor, err := ioutil.ReadFile(jb.config.CursorStateFile); err != nil {
-			logp.Warn("Could not seek to cursor: reading cursor state file failed: %v", err)
-		} else {
-			// try to seek to cursor and if successful return
-			if err = seekToHelper(config.SeekPositionCursor, jb.journal.SeekCursor(string(cursor))); err == nil {
-				return nil
-			}
+	if err = journal.Verify(files, r.config.FSSKeyFile); err != nil {
+		if r.config.FSSOnFailure == config.FSSOnFailureWarn {
+			logp.Warn("Verifying the journal failed: %v", err)
+			return nil
 		}
+		return fmt.Errorf("Verifying the journal failed: %v", err)
+	}
+	logp.Info("Verified %d journal files", len(files))
+	return nil
+}
 
-		if jb.config.CursorSeekFallback == config.SeekPositionDefault {
-			return err
+// checkJournalPath checks that a journal path exists and that a directory holds journal files,
+// directly or in a subdirectory like the machine ID directories. libsystemd only reports an
+// error number for both cases.
+func checkJournalPath(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Journal path %s: %v", path, err)
+	}
+	if !fi.IsDir() {
+		return nil
+	}
+
+	for _, pattern := range []string{"*.journal", "*.journal~", "*/*.journal", "*/*.journal~"} {
+		if files, _ := filepath.Glob(filepath.Join(path, pattern)); len(files) > 0 {
+			return nil
 		}
+	}
+	return fmt.Errorf("Journal path %s: the directory contains no journal files", path)
+}
+
+// reopenJournal closes the journal and opens it again, positioned after the entry at the given
+// cursor. Without a cursor no entry was handed over yet, so the journal is positioned where it
+// was positioned at start up, without seeking anew.
+func (r *journalReader) reopenJournal(cursor string) error {
+	r.journalMu.Lock()
+	defer r.journalMu.Unlock()
 
-		position = jb.config.CursorSeekFallback
+	if r.journal != nil {
+		_ = r.journal.Close()
+		r.journal = nil
 	}
 
-	switch position {
-	case config.SeekPositionHead:
-		err = seekToHelper(config.SeekPositionHead, jb.journal.SeekHead())
-	case config.SeekPositionTail:
-		err = seekToHelper(config.SeekPositionTail, jb.journal.SeekTail())
+	if err := r.openJournal(); err != nil {
+		return err
+	}
+	if cursor == "" {
+		return r.seekStart()
+	}
+	if err := r.journal.SeekCursor(cursor); err != nil {
+		return fmt.Errorf("Could not seek to cursor %s: %v", cursor, err)
 	}
+	// the entry at the cursor has been handed over already, so skip it
+	if _, err := r.journal.Next(); err != nil {
+		return fmt.Errorf("Could not skip the entry at cursor %s: %v", cursor, err)
+	}
+	return nil
+}
 
+// seekToStoredCursor seeks the journal to the cursor stored in the given cursor store
+func (r *journalReader) seekToStoredCursor(store cursorStore) error {
+	cursor, err := store.Load()
 	if err != nil {
-		return fmt.Errorf("Seeking to a good position in journal failed: %v", err)
+		return fmt.Errorf("reading %s failed: %v", store, err)
+	}
+
+	if err = r.journal.SeekCursor(cursor); err != nil {
+		return fmt.Errorf("%s: %v", store, err)
 	}
 
+	exact, err := r.testCursor(cursor)
+	if err != nil {
+		return fmt.Errorf("%s: %v", store, err)
+	}
+	if !exact {
+		cursorGaps.Inc()
+		logp.Warn("The entry at the cursor of %s is not in the journal anymore, it was probably rotated away: the entries in between are lost", store)
+		if r.config.StrictCursor {
+			return fmt.Errorf("%s: the entry at the cursor is not in the journal anymore", store)
+		}
+	}
+	r.startCursor = cursor
 	return nil
 }
 
-// Add syslog identifiers to monitor
-func (jb *Journalbeat) addSyslogIdentifiers() error {
-	var err error
+// seekStart positions the journal where initJournal positioned it at start up
+func (r *journalReader) seekStart() error {
+	switch {
+	case r.startCursor != "":
+		return r.journal.SeekCursor(r.startCursor)
+	case r.startUsec > 0:
+		return r.journal.SeekRealtimeUsec(r.startUsec)
+	}
+	return r.journal.SeekHead()
+}
 
-	for _, identifier := range jb.config.Identifiers {
-		if err = jb.journal.AddMatch(sdjournal.SD_JOURNAL_FIELD_SYSLOG_IDENTIFIER + "=" + identifier); err != nil {
-			return fmt.Errorf("Filtering syslog identifier %s failed: %v", identifier, err)
-		}
+// realtimeUsec returns the time in microseconds since the epoch, like the realtime timestamps
+// of the journal
+func realtimeUsec(t time.Time) uint64 {
+	return uint64(t.UnixNano() / int64(time.Microsecond))
+}
 
-		if err = jb.journal.AddDisjunction(); err != nil {
-			return fmt.Errorf("Filtering syslog identifier %s failed: %v", identifier, err)
+// testCursor reports whether the entry at the cursor sought to is still in the journal. If it is
+// not, seeking landed on the nearest entry instead. The journal is sought to the cursor again.
+func (r *journalReader) testCursor(cursor string) (bool, error) {
+	n, err := r.journal.Next()
+	if err != nil {
+		return false, fmt.Errorf("reading the entry at the cursor failed: %v", err)
+	}
+
+	exact := false
+	if n > 0 {
+		switch err = r.journal.TestCursor(cursor); err {
+		case nil:
+			exact = true
+		case sdjournal.ErrNoTestCursor:
+		default:
+			return false, err
 		}
 	}
+	// go back to the cursor, following moves to the next entry first
+	return exact, r.journal.SeekCursor(cursor)
+}
 
-	return nil
+// capCursorAge checks the entry at the cursor sought to and seeks to the first entry within the
+// max age instead if it is older, which bounds the backlog replayed after a long downtime.
+func (r *journalReader) capCursorAge(maxAge time.Duration) error {
+	n, err := r.journal.Next()
+	if err != nil {
+		return fmt.Errorf("Reading the entry at the cursor failed: %v", err)
+	}
+	if n == 0 {
+		// nothing was written after the cursor
+		return nil
+	}
+
+	usec, err := r.journal.GetRealtimeUsec()
+	if err != nil {
+		return fmt.Errorf("Reading the timestamp at the cursor failed: %v", err)
+	}
+	cursor, err := r.journal.GetCursor()
+	if err != nil {
+		return fmt.Errorf("Reading the cursor failed: %v", err)
+	}
+
+	since := time.Now().Add(-maxAge)
+	if at := time.Unix(0, int64(usec)*1000); at.Before(since) {
+		logp.Warn("The entry at the cursor is from %v, older than the cursor max age %v: seeking to %v instead", at, maxAge, since)
+		r.startCursor, r.startUsec = "", realtimeUsec(since)
+		return r.journal.SeekRealtimeUsec(r.startUsec)
+	}
+	// go back to the cursor, following moves to the next entry first
+	return r.journal.SeekCursor(cursor)
 }
 
 func (jb *Journalbeat) publishPending() error {
+	if jb.config.PendingQueue.File == "" {
+		return nil
+	}
+
 	refs := []*eventReference{}
-	pending := map[string]common.MapStr{}
 	file, err := os.Open(jb.config.PendingQueue.File)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	if err = json.NewDecoder(file).Decode(&pending); err != nil {
+	// the queue is read regardless of the configured compression, so changing it does not lose the queue
+	buffered := bufio.NewReader(file)
+	var r io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	pending, err := readPendingQueue(r)
+	if err != nil {
 		return err
 	}
 
+	// the events which failed to be acked too often are moved to the dead letter file
+	deadLetters := map[string]queuedEvent{}
+	for cursor, queued := range pending {
+		queued.Attempts++
+		if jb.config.PendingQueue.MaxRetries > 0 && queued.Attempts > jb.config.PendingQueue.MaxRetries {
+			deadLetters[cursor] = queued
+			delete(pending, cursor)
+			continue
+		}
+		pending[cursor] = queued
+	}
+	if len(deadLetters) > 0 {
+		logp.Warn("Moving %d events loaded more than %d times to %s", len(deadLetters), jb.config.PendingQueue.MaxRetries, jb.config.PendingQueue.DeadLetterFile)
+		if err = writeDeadLetters(jb.config.PendingQueue.DeadLetterFile, deadLetters); err != nil {
+			logp.Err("Could not write the dead letter file %s: %v", jb.config.PendingQueue.DeadLetterFile, err)
+		}
+	}
+
 	logp.Info("Loaded %d events, trying to publish", len(pending))
-	for cursor, event := range pending {
+	for cursor, queued := range pending {
+		event := queued.Event
 		// We need to convert the timestamp back to the correct type before trying to publish
 		timestamp, _ := time.Parse(time.RFC3339, event["@timestamp"].(string))
 		event["@timestamp"] = common.Time(timestamp)
-		ref := &eventReference{cursor, event}
+		ref := &eventReference{cursor: cursor, body: event, attempts: queued.Attempts}
+		if jb.config.DedupeOnStart {
+			jb.republished.add(cursor)
+		}
 		jb.pending <- ref
 		refs = append(refs, ref)
 	}
@@ -189,27 +505,193 @@ func (jb *Journalbeat) publishPending() error {
 			return nil
 		default:
 			// we need to clone to avoid races since map is a pointer...
-			jb.client.PublishEvent(ref.body.Clone(), publisher.Signal(&eventSignal{ref, jb.completed}), publisher.Guaranteed)
+			if jb.client.PublishEvent(ref.body.Clone(), jb.publishOptions(jb.newEventSignal(ref))...) {
+				eventsPublished.Inc()
+				eventsPending.Inc()
+			}
 		}
 	}
 
 	return nil
 }
 
+// publishProcessingError publishes an event about a failed processing step of the entry with the given cursor
+func (jb *Journalbeat) publishProcessingError(cursor, processingErr string) {
+	event := common.MapStr{
+		"@timestamp": common.Time(time.Now()),
+		"type":       jb.config.DefaultType,
+		"event":      common.MapStr{"kind": "pipeline_error"},
+		"error":      common.MapStr{"message": processingErr},
+		"cursor":     cursor,
+	}
+	jb.client.PublishEvent(event, publisher.Guaranteed)
+}
+
+// publishHeartbeat publishes a heartbeat event for the heartbeat entry handed over by
+// following an idle journal
+func (jb *Journalbeat) publishHeartbeat(heartbeat *sdjournal.JournalEntry, entries string) {
+	count, _ := strconv.Atoi(entries)
+	event := common.MapStr{
+		"@timestamp": common.Time(entryTimestamp(heartbeat, false)),
+		"type":       "journalbeat_heartbeat",
+		"journalbeat": common.MapStr{
+			"heartbeat": common.MapStr{"entries": count},
+		},
+		"cursor": heartbeat.Cursor,
+	}
+	if name, ok := heartbeat.Fields[journal.SD_JOURNAL_FIELD_SOURCE]; ok {
+		_, _ = event.Put("journalbeat.source", name)
+	}
+	jb.client.PublishEvent(event)
+}
+
+// publishShutdownSummary publishes the totals of this run together with the last cursor
+// published and waits for the summary to be acked, at most for the shutdown summary timeout
+func (jb *Journalbeat) publishShutdownSummary(cursor string) {
+	event := common.MapStr{
+		"@timestamp": common.Time(time.Now()),
+		"type":       jb.config.DefaultType,
+		"event":      common.MapStr{"kind": "summary"},
+		"journalbeat": common.MapStr{
+			"summary": common.MapStr{
+				"read":      eventsRead.Get(),
+				"published": eventsPublished.Get(),
+				"failed":    eventsFailed.Get(),
+			},
+		},
+		"cursor": cursor,
+	}
+
+	signal := make(chan op.SignalResponse, 1)
+	callback := op.SignalCallback(func(response op.SignalResponse) { signal <- response })
+	if !jb.client.PublishEvent(event, publisher.Signal(callback), publisher.Guaranteed) {
+		logp.Warn("Could not publish the shutdown summary")
+		return
+	}
+
+	select {
+	case response := <-signal:
+		if response != op.SignalCompleted {
+			logp.Warn("Publishing the shutdown summary failed")
+		}
+	case <-time.After(jb.config.ShutdownSummaryTimeout):
+		logp.Warn("The shutdown summary was not acked within %v", jb.config.ShutdownSummaryTimeout)
+	}
+}
+
+// publishFileInput publishes the lines of a tailed file through the same pipeline as the journal entries
+func (jb *Journalbeat) publishFileInput(input config.FileInput, lines <-chan *fileinput.Line) {
+	defer jb.fileInputs.Done()
+
+	eventType := input.Type
+	if eventType == "" {
+		eventType = jb.config.DefaultType
+	}
+
+	for line := range lines {
+		event := common.MapStr{
+			"@timestamp": common.Time(line.Time),
+			"type":       eventType,
+			"message":    line.Text,
+			"source":     line.Path,
+			"offset":     line.Offset,
+		}
+
+		ref := &eventReference{cursor: fmt.Sprintf("file;%s;%d", line.Path, line.Offset), body: event}
+		if jb.client.PublishEvent(event, jb.publishOptions(jb.newEventSignal(ref))...) {
+			eventsPublished.Inc()
+			eventsPending.Inc()
+			jb.pending <- ref
+		}
+	}
+}
+
+// newJournalbeat sets up the state of a Journalbeat with the given configuration, without the
+// journal and the client
+func newJournalbeat(cfg config.Config) (*Journalbeat, error) {
+	jb := &Journalbeat{
+		config:    cfg,
+		done:      make(chan struct{}),
+		draining:  make(chan struct{}),
+		pending:   make(chan *eventReference),
+		completed: newCompletedQueue(cfg.PendingQueue.CompletedQueueSize),
+
+		excludedPriorities: map[int]struct{}{},
+		sanitizer:          newSanitizer(cfg.SanitizeKeys),
+		republished:        newCursorSet(),
+	}
+	jb.journalReader = &journalReader{config: &jb.config, cursorChan: make(chan string)}
+	var err error
+	if jb.cursorStore, err = newCursorStore(&jb.config); err != nil {
+		return nil, err
+	}
+	return jb, nil
+}
+
 // New creates beater
 func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
-	config := config.DefaultConfig
-	var err error
-	if err = cfg.Unpack(&config); err != nil {
+	config, err := unpackConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	jb, err := newJournalbeat(config)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// the journals are only closed by Run, or here if setting up Journalbeat failed
+		if err != nil {
+			if jb.journal != nil {
+				_ = jb.journal.Close()
+			}
+			for _, src := range jb.sources {
+				_ = src.journal.Close()
+			}
+		}
+	}()
+
+	for _, priority := range config.ExcludePriorities {
+		jb.excludedPriorities[priority] = struct{}{}
+	}
+
+	if config.RateLimit.Default.Rate > 0 || len(config.RateLimit.Limits) > 0 {
+		jb.rateLimiter = newRateLimiter(config.RateLimit)
+	}
+
+	if jb.stopBound, err = newStopBound(config.StopPosition, config.StopValue); err != nil {
 		return nil, fmt.Errorf("Error reading config file: %v", err)
 	}
 
-	jb := &Journalbeat{
-		config:     config,
-		done:       make(chan struct{}),
-		cursorChan: make(chan string),
-		pending:    make(chan *eventReference),
-		completed:  make(chan *eventReference, config.PendingQueue.CompletedQueueSize),
+	if config.EnableCatalog {
+		jb.catalog = journal.NewCatalog(config.CatalogRaw, config.CatalogCacheSize, config.CatalogSanitize)
+	}
+
+	if config.ExcludeSelf {
+		jb.selfPID, jb.selfComm = selfProcess()
+	}
+
+	if config.AddHostMetadata {
+		jb.hostMetadata = hostMetadata()
+	}
+
+	if config.ForwardSocket != "" {
+		jb.forwarder = newSocketForwarder(config.ForwardSocket)
+	}
+
+	if config.ContextBefore > 0 {
+		jb.contextLines = journal.NewContextLines(config.ContextBefore, config.ContextMaxPriority)
+	}
+
+	// the dry run neither stores the cursor nor the pending queue, so it does not change the state of a real run
+	if *dryRun || *printStats || config.DryRun {
+		jb.config.DryRun = true
+		jb.config.WriteCursorState = false
+		jb.config.PendingQueue.File = ""
+	}
+
+	if jb.unitPatterns, err = compileUnitPatterns(config.UnitPatterns); err != nil {
+		return nil, fmt.Errorf("Error reading config file: %v", err)
 	}
 
 	if err = jb.initJournal(); err != nil {
@@ -217,27 +699,76 @@ func New(b *beat.Beat, cfg *common.Config) (beat.Beater, error) {
 		return nil, err
 	}
 
-	jb.client = b.Publisher.Connect()
+	for _, source := range jb.config.JournalSources {
+		var src *journalReader
+		if src, err = jb.newSource(source); err != nil {
+			logp.Err("Failed to connect to the Systemd Journal: %v", err)
+			return nil, err
+		}
+		jb.sources = append(jb.sources, src)
+	}
+
+	if jb.config.DryRun {
+		logp.Info("Dry run: printing the events instead of publishing them")
+		jb.client = newDryRunClient(os.Stdout)
+	} else if jb.config.FileOutput.Path != "" {
+		logp.Info("Writing the events to %s instead of publishing them", jb.config.FileOutput.Path)
+		if jb.client, err = newFileOutputClient(jb.config.FileOutput, jb.config.DurableWrites); err != nil {
+			return nil, fmt.Errorf("Could not open the file output: %v", err)
+		}
+	} else {
+		jb.client = b.Publisher.Connect()
+	}
 	return jb, nil
 }
 
 // Run is the main event loop: read from journald and pass it to Publish
 func (jb *Journalbeat) Run(b *beat.Beat) error {
-	publishedChan := make(chan bool, 1)
+	if *printStats {
+		defer func() {
+			_ = jb.journal.Close()
+			jb.closeSources()
+		}()
+		return jb.writeJournalStats(os.Stdout)
+	}
+
 	logp.Info("Journalbeat is running!")
+	var entries <-chan *sdjournal.JournalEntry
 	defer func() {
+		// Run only returns once jb.done is closed, by Stop or by followEnded, so following ends
+		// and it has to end before the journals are closed
+		if entries != nil {
+			for range entries {
+			}
+		}
+		jb.fileInputs.Wait()
+		jb.reporters.Wait()
+		if jb.forwarder != nil {
+			jb.forwarder.close()
+		}
+		if jb.config.EmitShutdownSummary {
+			jb.publishShutdownSummary(jb.lastCursor)
+		}
 		_ = jb.client.Close()
-		_ = jb.journal.Close()
-		close(jb.cursorChan)
-		close(jb.completed)
-		close(jb.pending)
+		if jb.journal != nil {
+			_ = jb.journal.Close()
+		}
+		jb.closeSources()
+		jb.closeQueues()
+		jb.cursorLoop.Wait()
 		jb.wg.Wait()
 	}()
 
+	jb.wg.Add(1)
 	go jb.managePendingQueueLoop()
 
 	if jb.config.WriteCursorState {
+		jb.cursorLoop.Add(1)
 		go jb.writeCursorLoop()
+		for _, src := range jb.sources {
+			src.cursorLoop.Add(1)
+			go src.writeCursorLoop()
+		}
 	}
 
 	// load the previously saved queue of unsent events and try to publish them if any
@@ -245,43 +776,486 @@ func (jb *Journalbeat) Run(b *beat.Beat) error {
 		logp.Warn("could not read the pending queue: %s", err)
 	}
 
-	for rawEvent := range journal.Follow(jb.journal, jb.done) {
-		//convert sdjournal.JournalEntry to common.MapStr
-		event := MapStrFromJournalEntry(
-			rawEvent,
-			jb.config.CleanFieldNames,
-			jb.config.ConvertToNumbers,
-			jb.config.MoveMetadataLocation,
-			jb.config.ParsePriority,
-			jb.config.ParseSyslogFacility)
+	if jb.config.UsageReportPeriod > 0 {
+		jb.reporters.Add(1)
+		go jb.reportUsageLoop()
+	}
+
+	jb.reload = make(chan config.Config)
+	jb.reporters.Add(1)
+	go jb.reloadLoop(b.Name)
+
+	for _, input := range jb.config.FileInputs {
+		jb.fileInputs.Add(1)
+		go jb.publishFileInput(input, fileinput.Tail(input.Path, input.OffsetFile, jb.done))
+	}
+
+	entries = jb.followSources()
+	if jb.config.WorkerCount > 1 {
+		return jb.publishWorkers(entries)
+	}
+
+	if jb.config.BatchSize > 1 {
+		for batch := range journal.Batch(entries, jb.config.BatchSize, jb.done) {
+			refs := make([]*eventReference, 0, len(batch))
+			stop := false
+			for _, rawEvent := range batch {
+				ref := jb.eventFromEntry(rawEvent)
+				if ref == nil {
+					continue
+				}
+				if jb.stopBound != nil && jb.stopBound.exceeded(rawEvent) {
+					stop = true
+					break
+				}
+				refs = append(refs, ref)
+				if jb.stopBound != nil && jb.stopBound.reached(rawEvent) {
+					stop = true
+					break
+				}
+			}
+			if len(refs) > 0 && (!jb.waitForPending() || !jb.publishBatch(refs)) {
+				return nil
+			}
+			if stop {
+				jb.stopAtBound()
+				return nil
+			}
+		}
+		return jb.followEnded()
+	}
+
+	publishedChan := make(chan bool, 1)
+	for rawEvent := range entries {
+		ref := jb.eventFromEntry(rawEvent)
+		if ref == nil {
+			continue
+		}
 
-		if _, ok := event["type"].(string); !ok {
-			event["type"] = jb.config.DefaultType
+		if jb.stopBound != nil && jb.stopBound.exceeded(rawEvent) {
+			jb.stopAtBound()
+			return nil
 		}
-		event["@timestamp"] = common.Time(time.Unix(0, int64(rawEvent.RealtimeTimestamp)*1000))
-		// add _REALTIME_TIMESTAMP until https://github.com/elastic/elasticsearch/issues/12829 is closed
-		event["@realtime_timestamp"] = int64(rawEvent.RealtimeTimestamp)
 
-		ref := &eventReference{rawEvent.Cursor, event}
+		if !jb.waitForPending() {
+			return nil
+		}
+
+		jb.forward(ref)
 		select {
 		case <-jb.done:
 			return nil
-		case publishedChan <- jb.client.PublishEvent(event, publisher.Signal(&eventSignal{ref, jb.completed}), publisher.Guaranteed):
+		case publishedChan <- jb.client.PublishEvent(ref.body, jb.publishOptions(jb.newEventSignal(ref))...):
 			if published := <-publishedChan; published {
-				jb.pending <- ref
+				jb.published(ref)
+			}
+		}
+
+		if jb.stopBound != nil && jb.stopBound.reached(rawEvent) {
+			jb.stopAtBound()
+			return nil
+		}
+	}
+	return jb.followEnded()
+}
+
+// followEnded stops Journalbeat once following the journals ended on its own, e.g. after the
+// follow restarts are used up, so the loops waiting for jb.done end too. It returns the error
+// following ended with.
+func (jb *Journalbeat) followEnded() error {
+	jb.Stop()
+	return jb.followErr
+}
+
+// closeQueues closes the channels of the cursor and the pending queue, which ends the loops
+// reading them. The channels are closed once and only after following ended.
+func (jb *Journalbeat) closeQueues() {
+	jb.closeOnce.Do(func() {
+		close(jb.cursorChan)
+		jb.completed.close()
+		close(jb.pending)
+	})
+}
+
+// eventFromEntry converts a journal entry to the event to publish. It returns nil if the
+// entry is dropped.
+func (jb *Journalbeat) eventFromEntry(rawEvent *sdjournal.JournalEntry) *eventReference {
+	if entries, ok := rawEvent.Fields[journal.SD_JOURNAL_FIELD_HEARTBEAT]; ok {
+		jb.publishHeartbeat(rawEvent, entries)
+		return nil
+	}
+
+	eventsRead.Inc()
+
+	// an entry with a failed processing step is still published with the data that could be read
+	if processingErr, ok := rawEvent.Fields[journal.SD_JOURNAL_FIELD_PROCESSING_ERROR]; ok {
+		delete(rawEvent.Fields, journal.SD_JOURNAL_FIELD_PROCESSING_ERROR)
+		logp.Warn("Processing of the entry with cursor %s failed: %s", rawEvent.Cursor, processingErr)
+		if jb.config.EmitProcessingErrors {
+			jb.publishProcessingError(rawEvent.Cursor, processingErr)
+		}
+	}
+
+	var source *journalReader
+	if name, ok := rawEvent.Fields[journal.SD_JOURNAL_FIELD_SOURCE]; ok {
+		delete(rawEvent.Fields, journal.SD_JOURNAL_FIELD_SOURCE)
+		for _, src := range jb.sources {
+			if src.name == name {
+				source = src
+			}
+		}
+	}
+
+	// every entry read counts for the boot boundary, even if it is dropped
+	if jb.config.EmitBootMarkers {
+		jb.checkBootBoundary(rawEvent, source)
+	}
+
+	var contextLines []string
+	if encoded, ok := rawEvent.Fields[journal.SD_JOURNAL_FIELD_CONTEXT]; ok {
+		delete(rawEvent.Fields, journal.SD_JOURNAL_FIELD_CONTEXT)
+		if err := json.Unmarshal([]byte(encoded), &contextLines); err != nil {
+			logp.Warn("Could not decode the context lines of the entry with cursor %s: %v", rawEvent.Cursor, err)
+		}
+	}
+
+	if jb.dropEntry(rawEvent) {
+		eventsDropped.Inc()
+		return nil
+	}
+
+	if jb.config.RequireMessage && rawEvent.Fields[jb.config.MessageField] == "" {
+		eventsDropped.Inc()
+		eventsNoMessage.Inc()
+		return nil
+	}
+
+	if jb.rateLimiter != nil && !jb.rateLimiter.allow(rawEvent, time.Now()) {
+		eventsDropped.Inc()
+		eventsLimited.Inc()
+		return nil
+	}
+
+	if jb.pendingFull() {
+		eventsDropped.Inc()
+		return nil
+	}
+
+	if jb.config.DedupeOnStart && jb.republished.contains(rawEvent.Cursor) {
+		eventsDropped.Inc()
+		logp.Debug("journalbeat", "Skipping the entry with cursor %s, it was republished from the pending queue", rawEvent.Cursor)
+		return nil
+	}
+
+	//convert sdjournal.JournalEntry to common.MapStr
+	event := MapStrFromJournalEntry(rawEvent, &jb.config, jb.sanitizer)
+
+	if _, ok := event["type"].(string); !ok {
+		event["type"] = jb.config.DefaultType
+	}
+	if _, ok := event["input_type"]; !ok && jb.config.InputType != "" {
+		event["input_type"] = jb.config.InputType
+	}
+	timestamp := entryTimestamp(rawEvent, jb.config.UseSourceTimestamp)
+	// the timestamp field is configured explicitly, so it wins over the timestamps of the entry
+	if value, ok := rawEvent.Fields[jb.config.TimestampField]; ok && jb.config.TimestampField != "" {
+		if t, err := parseEpoch(value, jb.config.TimestampUnit); err == nil {
+			timestamp = t
+		} else {
+			logp.Debug("journalbeat", "Invalid timestamp in %s: %v", jb.config.TimestampField, err)
+		}
+	}
+	event["@timestamp"] = common.Time(timestamp)
+	// _REALTIME_TIMESTAMP in microseconds, @timestamp only has a precision of milliseconds
+	if jb.config.RealtimeTimestampField != "" {
+		_, _ = event.Put(jb.config.RealtimeTimestampField, int64(rawEvent.RealtimeTimestamp))
+	}
+
+	// the cursor is put from the top level of the event, so it stays in place whatever the metadata location
+	if jb.config.IncludeCursor && rawEvent.Cursor != "" {
+		_, _ = event.Put(jb.config.CursorField, rawEvent.Cursor)
+	}
+
+	// the static fields go to the top level of the event whatever the metadata location,
+	// under root they never overwrite the fields of the entry
+	if jb.config.FieldsUnderRoot {
+		for k, v := range jb.config.Fields {
+			if _, ok := event[k]; !ok {
+				event[k] = v
+			}
+		}
+	} else if err := common.MergeFields(event, jb.config.Fields, false); err != nil {
+		logp.Warn("Could not add the fields to the entry with cursor %s: %v", rawEvent.Cursor, err)
+	}
+
+	// the message is truncated before it is formatted, so the @cee: JSON stays intact
+	if jb.config.MaxMessageChars > 0 {
+		messageKey := makeNewKey(sdjournal.SD_JOURNAL_FIELD_MESSAGE, jb.config.CleanFieldNames)
+		if text, ok := event[messageKey].(string); ok {
+			event[messageKey] = truncateMessage(text, jb.config.MaxMessageChars)
+		}
+	}
+
+	if jb.config.MessageFormat == config.MessageFormatCEE {
+		if err := ceeMessage(event, jb.config.CEEFields, makeNewKey(sdjournal.SD_JOURNAL_FIELD_MESSAGE, jb.config.CleanFieldNames)); err != nil {
+			logp.Warn("Could not format the message of the entry with cursor %s: %v", rawEvent.Cursor, err)
+		}
+	}
+
+	// the pending queue is keyed by cursor, entries without one need a unique key of their own
+	key := rawEvent.Cursor
+	if key == "" {
+		jb.syntheticKeys++
+		key = fmt.Sprintf("synthetic;t=%x;q=%x;n=%x", rawEvent.RealtimeTimestamp, rawEvent.Seqnum, jb.syntheticKeys)
+		logp.Warn("Journal entry without a cursor, tracking it as %s", key)
+	}
+
+	if jb.config.EmbedStatsEvery > 0 {
+		if jb.embedStatsCount++; jb.embedStatsCount%jb.config.EmbedStatsEvery == 0 {
+			_, _ = event.Put("journalbeat.stats", jb.stats())
+		}
+	}
+
+	if source != nil {
+		_, _ = event.Put("journalbeat.source", source.name)
+	}
+
+	if len(contextLines) > 0 {
+		event["context_lines"] = contextLines
+	}
+
+	if jb.hostMetadata != nil {
+		addHostMetadata(event, jb.hostMetadata)
+	}
+
+	// the GELF message is reshaped from the complete event
+	if jb.config.OutputFormat == config.OutputFormatGELF {
+		event = gelfEvent(event, rawEvent, timestamp, makeNewKey(sdjournal.SD_JOURNAL_FIELD_MESSAGE, jb.config.CleanFieldNames))
+	}
+
+	return &eventReference{cursor: key, body: event, journalCursor: rawEvent.Cursor, source: source}
+}
+
+// publishOptions returns the options of the publisher for the publish mode, signaling the
+// outcome to signal. Only the guaranteed mode retries until the events are acked, the sync
+// mode waits for the events to be acked or to fail. The cursor advances on the hand-over in
+// every mode, the guaranteed mode relies on the pending queue for the events not acked yet.
+func (jb *Journalbeat) publishOptions(signal op.Signaler) []publisher.ClientOption {
+	switch jb.config.PublishMode {
+	case config.PublishModeGuaranteed:
+		return []publisher.ClientOption{publisher.Signal(signal), publisher.Guaranteed}
+	case config.PublishModeSync:
+		return []publisher.ClientOption{publisher.Signal(signal), publisher.Sync}
+	}
+	return []publisher.ClientOption{publisher.Signal(signal)}
+}
+
+// dropFailed reports whether failed events are given up instead of being kept in the pending
+// queue, which is the case unless the delivery is guaranteed
+func (jb *Journalbeat) dropFailed() bool {
+	return jb.config.PublishMode != config.PublishModeGuaranteed
+}
+
+// publishBatch publishes the events at once. It returns false if Journalbeat was stopped.
+func (jb *Journalbeat) publishBatch(refs []*eventReference) bool {
+	events := make([]common.MapStr, len(refs))
+	for i, ref := range refs {
+		jb.forward(ref)
+		events[i] = ref.body
+	}
+
+	publishedChan := make(chan bool, 1)
+	select {
+	case <-jb.done:
+		return false
+	case publishedChan <- jb.client.PublishEvents(events, jb.publishOptions(jb.newBatchSignal(refs))...):
+		if published := <-publishedChan; published {
+			for _, ref := range refs {
+				jb.published(ref)
+			}
+		}
+	}
+	return true
+}
+
+// forward writes the event to the forward socket, if any, right before it is published
+func (jb *Journalbeat) forward(ref *eventReference) {
+	if jb.forwarder != nil {
+		jb.forwarder.forward(ref.body)
+	}
+}
+
+// published records an event handed over to the publisher in the pending queue and the cursor state
+func (jb *Journalbeat) published(ref *eventReference) {
+	// the publish workers hand over their events concurrently
+	jb.publishedMu.Lock()
+	defer jb.publishedMu.Unlock()
+
+	eventsPublished.Inc()
+	eventsPending.Inc()
+	jb.pending <- ref
+	if ref.journalCursor == "" {
+		return
+	}
+
+	// the cursor of an entry of a source is saved by the source
+	cursorChan := jb.cursorChan
+	if ref.source != nil {
+		cursorChan = ref.source.cursorChan
+	} else {
+		jb.lastCursor = ref.journalCursor
+	}
+
+	// save cursor
+	if jb.config.WriteCursorState {
+		cursorChan <- ref.journalCursor
+	}
+}
+
+// follow follows the journal of the reader like journal.Follow does. If following ends without
+// Journalbeat being stopped, e.g. because of a panic, following is restarted from the last cursor
+// handed over, up to MaxFollowRestarts times. Only the main journal is reloaded on SIGHUP.
+func (jb *Journalbeat) follow(r *journalReader) <-chan *sdjournal.JournalEntry {
+	var reloads <-chan config.Config
+	if r == jb.journalReader {
+		reloads = jb.reload
+	}
+
+	out := make(chan *sdjournal.JournalEntry)
+
+	go func() {
+		defer close(out)
+
+		var cursor string
+		restarts := 0
+		for {
+			// following is stopped on its own when the configuration is reloaded
+			stop := make(chan struct{})
+			entries := followJournal(r.journal, stop, jb.catalog, jb.contextLines, jb.config.HeartbeatPeriod)
+			var reload *config.Config
+		forward:
+			for {
+				select {
+				case <-jb.done:
+					break forward
+				case cfg := <-reloads:
+					reload = &cfg
+					break forward
+				case entry, ok := <-entries:
+					if !ok {
+						break forward
+					}
+					select {
+					case <-jb.done:
+						break forward
+					case out <- entry:
+						// a heartbeat of a restarted follow has no cursor yet
+						if entry.Cursor != "" {
+							cursor = entry.Cursor
+						}
+					}
+				}
+			}
+			close(stop)
+			// the journal is only changed or closed once following it ended
+			for range entries {
+			}
+
+			select {
+			case <-jb.done:
+				return
+			default:
+			}
+
+			if reload != nil {
+				if err := jb.applyReload(*reload, cursor); err != nil {
+					r.followErr = err
+					logp.Err("%v", r.followErr)
+					return
+				}
+				continue
+			}
 
-				// save cursor
-				if jb.config.WriteCursorState {
-					jb.cursorChan <- rawEvent.Cursor
+			if jb.config.ReconnectBackoffMax > 0 {
+				if !jb.reconnect(r, cursor) {
+					return
+				}
+				continue
+			}
+
+			if restarts >= jb.config.MaxFollowRestarts {
+				r.followErr = fmt.Errorf("Following the journal ended unexpectedly %d times, giving up", restarts+1)
+				logp.Err("%v", r.followErr)
+				return
+			}
+			restarts++
+
+			logp.Warn("Following the journal ended unexpectedly, restarting from cursor %s", cursor)
+			if cursor != "" {
+				// the entry at the cursor has been handed over already, so skip it
+				if err := r.journal.SeekCursor(cursor); err != nil {
+					logp.Err("Could not seek to cursor %s: %v", cursor, err)
+				} else if _, err = r.journal.Next(); err != nil {
+					logp.Err("Could not skip the entry at cursor %s: %v", cursor, err)
 				}
 			}
 		}
+	}()
+
+	return out
+}
+
+// reconnect reopens the journal of the reader after following it ended unexpectedly and positions
+// it after the given cursor. Failed attempts are retried with an exponential backoff up to the
+// reconnect backoff max. It returns false if Journalbeat was stopped in the meantime.
+func (jb *Journalbeat) reconnect(r *journalReader, cursor string) bool {
+	backoff := time.Second
+	for {
+		logp.Warn("Following the journal ended unexpectedly, reopening it at cursor %s", cursor)
+		err := r.reopenJournal(cursor)
+		if err == nil {
+			return true
+		}
+		logp.Err("Reopening the journal failed, retrying in %v: %v", backoff, err)
+
+		select {
+		case <-jb.done:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > jb.config.ReconnectBackoffMax {
+			backoff = jb.config.ReconnectBackoffMax
+		}
 	}
-	return nil
 }
 
 // Stop stops Journalbeat execution
 func (jb *Journalbeat) Stop() {
-	logp.Info("Stopping Journalbeat")
-	close(jb.done)
+	// Journalbeat stops on its own at the stop position, which may race with a shutdown signal
+	jb.stopOnce.Do(func() {
+		logp.Info("Stopping Journalbeat")
+		if jb.config.ShutdownDrainTimeout > 0 {
+			jb.drain(jb.config.ShutdownDrainTimeout)
+		}
+		close(jb.done)
+	})
+}
+
+// drain stops publishing further events and waits for the pending events to be acked, at
+// most for the timeout, so fewer events are saved to the pending queue and sent again
+func (jb *Journalbeat) drain(timeout time.Duration) {
+	close(jb.draining)
+
+	ticker := time.NewTicker(backpressurePollPeriod)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for eventsPending.Get() > 0 {
+		select {
+		case <-deadline:
+			logp.Warn("%d events are still pending after %v, saving them to the pending queue", eventsPending.Get(), timeout)
+			return
+		case <-ticker.C:
+		}
+	}
+	logp.Info("All pending events were acked")
 }
@@ -0,0 +1,607 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
+	"github.com/elastic/beats/libbeat/monitoring"
+	"github.com/elastic/beats/libbeat/publisher"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// testClient records the published events and completes them right away, unless it fails them
+type testClient struct {
+	mu     sync.Mutex
+	events []common.MapStr
+	fail   bool
+}
+
+func (c *testClient) Close() error { return nil }
+
+func (c *testClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c *testClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	_, ctx := publisher.MakeContext(opts)
+	c.mu.Lock()
+	c.events = append(c.events, events...)
+	fail := c.fail
+	c.mu.Unlock()
+	if fail {
+		op.SigFailed(ctx.Signal, errors.New("test failure"))
+	} else {
+		op.SigCompleted(ctx.Signal)
+	}
+	return true
+}
+
+// published returns the events published so far
+func (c *testClient) published() []common.MapStr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]common.MapStr(nil), c.events...)
+}
+
+// testConfig returns the default configuration without the cursor state, the pending queue
+// is written to a temporary directory
+func testConfig(t testing.TB) config.Config {
+	cfg := config.DefaultConfig
+	cfg.WriteCursorState = false
+	cfg.PendingQueue.File = filepath.Join(t.TempDir(), "pending")
+	cfg.ExcludeSelf = false
+	// sets the default maps
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// newTestBeat returns a Journalbeat publishing to a test client, without a journal
+func newTestBeat(cfg config.Config) (*Journalbeat, *testClient) {
+	client := &testClient{}
+	jb, err := newJournalbeat(cfg)
+	if err != nil {
+		panic(err)
+	}
+	jb.client = client
+	return jb, client
+}
+
+// followScripted replaces following the journal with handing over the entries, after which
+// following ends as if the journal failed. It returns a function restoring journal.Follow.
+func followScripted(entries ...*sdjournal.JournalEntry) func() {
+	followJournal = func(_ journal.EntrySource, stop <-chan struct{}, _ *journal.Catalog, _ *journal.ContextLines, _ time.Duration) <-chan *sdjournal.JournalEntry {
+		out := make(chan *sdjournal.JournalEntry)
+		go func() {
+			defer close(out)
+			for _, entry := range entries {
+				select {
+				case <-stop:
+					return
+				case out <- entry:
+				}
+			}
+		}()
+		return out
+	}
+	return func() { followJournal = journal.Follow }
+}
+
+func TestRunReturnsWhenFollowingFails(t *testing.T) {
+	defer followScripted()()
+
+	cfg := testConfig(t)
+	cfg.MaxFollowRestarts = 1
+	jb, _ := newTestBeat(cfg)
+
+	result := make(chan error, 1)
+	go func() { result <- jb.Run(&beat.Beat{Name: "journalbeat"}) }()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Run returned no error although following failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after following failed")
+	}
+
+	select {
+	case <-jb.done:
+	default:
+		t.Error("Journalbeat was not stopped after following failed")
+	}
+}
+
+func TestRunRestartsFollowing(t *testing.T) {
+	// without a cursor the restarts do not seek the journal, there is none
+	defer followScripted(&sdjournal.JournalEntry{
+		Fields: map[string]string{"MESSAGE": "hello"},
+	})()
+
+	cfg := testConfig(t)
+	cfg.MaxFollowRestarts = 2
+	jb, client := newTestBeat(cfg)
+
+	result := make(chan error, 1)
+	go func() { result <- jb.Run(&beat.Beat{Name: "journalbeat"}) }()
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the follow restarts were used up")
+	}
+
+	// following the scripted journal ends after every entry, so it is followed once and restarted twice
+	if events := client.published(); len(events) != 3 {
+		t.Errorf("got %d events, want one per follow", len(events))
+	}
+}
+
+func TestEventFromEntryTruncatesBeforeCEE(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MessageFormat = config.MessageFormatCEE
+	cfg.MaxMessageChars = 5
+	jb, _ := newTestBeat(cfg)
+
+	ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{"MESSAGE": "hello world"}})
+	message, _ := ref.body["MESSAGE"].(string)
+	if !strings.HasPrefix(message, "@cee:") {
+		t.Fatalf("got message %q, want a @cee: message", message)
+	}
+	var cee map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(message, "@cee:")), &cee); err != nil {
+		t.Fatalf("the @cee: message %q is no valid JSON: %v", message, err)
+	}
+	if want := "hello…[truncated 6 bytes]"; cee["msg"] != want {
+		t.Errorf("got msg %q, want %q", cee["msg"], want)
+	}
+}
+
+func TestEventFromEntryMaxMessageChars(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        int
+		cleanNames bool
+		want       common.MapStr
+	}{
+		{"disabled", 0, false, common.MapStr{"MESSAGE": "hello world", "SYSLOG_IDENTIFIER": "application"}},
+		{"short enough", 20, false, common.MapStr{"MESSAGE": "hello world", "SYSLOG_IDENTIFIER": "application"}},
+		// only the message is truncated
+		{"truncated", 5, false, common.MapStr{"MESSAGE": "hello…[truncated 6 bytes]", "SYSLOG_IDENTIFIER": "application"}},
+		{"clean names", 5, true, common.MapStr{"message": "hello…[truncated 6 bytes]", "syslog_identifier": "application"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.MaxMessageChars = test.max
+			cfg.CleanFieldNames = test.cleanNames
+			jb, _ := newTestBeat(cfg)
+
+			ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{
+				"MESSAGE":           "hello world",
+				"SYSLOG_IDENTIFIER": "application",
+			}})
+			for key, want := range test.want {
+				if got := ref.body[key]; got != want {
+					t.Errorf("got %s %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventFromEntryProcessingErrors(t *testing.T) {
+	for _, emit := range []bool{false, true} {
+		cfg := testConfig(t)
+		cfg.EmitProcessingErrors = emit
+		jb, client := newTestBeat(cfg)
+
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{
+			"MESSAGE": "hello",
+			journal.SD_JOURNAL_FIELD_PROCESSING_ERROR: "catalog lookup failed: test",
+		}})
+		// the entry is published anyway, without the error
+		if ref == nil || ref.body["MESSAGE"] != "hello" {
+			t.Fatalf("emit %v: the entry was not converted: %v", emit, ref)
+		}
+		if _, ok := ref.body[journal.SD_JOURNAL_FIELD_PROCESSING_ERROR]; ok {
+			t.Errorf("emit %v: the processing error was kept in the event", emit)
+		}
+
+		events := client.published()
+		if !emit {
+			if len(events) != 0 {
+				t.Errorf("got error events %v, want none", events)
+			}
+			continue
+		}
+		if len(events) != 1 {
+			t.Fatalf("got %d error events, want 1", len(events))
+		}
+		kind, _ := events[0].GetValue("event.kind")
+		message, _ := events[0].GetValue("error.message")
+		if kind != "pipeline_error" || message != "catalog lookup failed: test" || events[0]["cursor"] != "c1" {
+			t.Errorf("got error event %v", events[0])
+		}
+	}
+}
+
+func TestEventFromEntryWithoutCursor(t *testing.T) {
+	jb, _ := newTestBeat(testConfig(t))
+
+	// the same entry twice without a cursor gets two different keys
+	keys := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{RealtimeTimestamp: 1, Fields: map[string]string{"MESSAGE": "hello"}})
+		if ref.journalCursor != "" {
+			t.Errorf("got journal cursor %q, want none", ref.journalCursor)
+		}
+		if !strings.HasPrefix(ref.cursor, "synthetic;") {
+			t.Errorf("got key %q, want a synthetic key", ref.cursor)
+		}
+		keys[ref.cursor] = true
+	}
+	if len(keys) != 2 {
+		t.Errorf("got keys %v, want two different ones", keys)
+	}
+
+	// the sequence number is part of the key
+	ref := jb.eventFromEntry(&sdjournal.JournalEntry{RealtimeTimestamp: 1, Seqnum: 42, Fields: map[string]string{"MESSAGE": "hello"}})
+	if !strings.Contains(ref.cursor, ";q=2a;") {
+		t.Errorf("got key %q, want it to contain the sequence number", ref.cursor)
+	}
+
+	ref = jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{"MESSAGE": "hello"}})
+	if ref.cursor != "c1" || ref.journalCursor != "c1" {
+		t.Errorf("got key %q and journal cursor %q, want c1", ref.cursor, ref.journalCursor)
+	}
+}
+
+func TestEventFromEntryTimestampField(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"1500000000123456789", time.Unix(1500000000, 123456789)},
+		// an invalid timestamp falls back to the realtime timestamp
+		{"yesterday", time.Unix(1, 0)},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.TimestampField = "APP_TIME"
+		cfg.TimestampUnit = config.TimestampUnitNanoseconds
+		jb, _ := newTestBeat(cfg)
+
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", RealtimeTimestamp: 1000000, Fields: map[string]string{
+			"MESSAGE":  "hello",
+			"APP_TIME": test.value,
+		}})
+		if got := time.Time(ref.body["@timestamp"].(common.Time)); !got.Equal(test.want) {
+			t.Errorf("APP_TIME %s: got @timestamp %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestEventFromEntryEmbedStatsEvery(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.EmbedStatsEvery = 3
+	jb, _ := newTestBeat(cfg)
+
+	var embedded []int
+	for i := 1; i <= 7; i++ {
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: fmt.Sprintf("c%d", i), Fields: map[string]string{"MESSAGE": "hello"}})
+		stats, err := ref.body.GetValue("journalbeat.stats")
+		if err != nil {
+			continue
+		}
+		embedded = append(embedded, i)
+		for _, counter := range []string{"read", "published", "failed", "pending"} {
+			if _, ok := stats.(common.MapStr)[counter]; !ok {
+				t.Errorf("event %d: the stats %v have no %s counter", i, stats, counter)
+			}
+		}
+	}
+	if want := []int{3, 6}; !reflect.DeepEqual(embedded, want) {
+		t.Errorf("got the stats embedded in the events %v, want %v", embedded, want)
+	}
+}
+
+func TestRunEmitsShutdownSummary(t *testing.T) {
+	defer followScripted(
+		&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{"MESSAGE": "one"}},
+		&sdjournal.JournalEntry{Cursor: "c2", Fields: map[string]string{"MESSAGE": "two"}},
+		&sdjournal.JournalEntry{Cursor: "c3", Fields: map[string]string{"MESSAGE": "three"}},
+	)()
+	// the counters are shared by the tests
+	for _, counter := range []*monitoring.Int{eventsRead, eventsPublished, eventsFailed} {
+		counter.Set(0)
+	}
+
+	cfg := testConfig(t)
+	cfg.MaxFollowRestarts = 0
+	cfg.EmitShutdownSummary = true
+	jb, client := newTestBeat(cfg)
+
+	result := make(chan error, 1)
+	go func() { result <- jb.Run(&beat.Beat{Name: "journalbeat"}) }()
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after following ended")
+	}
+
+	events := client.published()
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want the entries and the summary", len(events))
+	}
+	summary := events[3]
+	if kind, _ := summary.GetValue("event.kind"); kind != "summary" {
+		t.Fatalf("got %v as the last event, want the summary", summary)
+	}
+	want := common.MapStr{"read": int64(3), "published": int64(3), "failed": int64(0)}
+	if got, _ := summary.GetValue("journalbeat.summary"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got summary %v, want %v", got, want)
+	}
+	if summary["cursor"] != "c3" {
+		t.Errorf("got cursor %v, want c3", summary["cursor"])
+	}
+}
+
+func TestEventFromEntryTypes(t *testing.T) {
+	tests := []struct {
+		name                string
+		defaultType         string
+		inputType           string
+		fields              map[string]string
+		wantType, wantInput interface{}
+	}{
+		{"defaults", "journal", "journal", nil, "journal", "journal"},
+		{"independent", "syslog", "journald", nil, "syslog", "journald"},
+		{"no input type", "journal", "", nil, "journal", nil},
+		{"set by the entry", "journal", "journal", map[string]string{"TYPE": "app", "INPUT_TYPE": "file"}, "app", "file"},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.CleanFieldNames = true
+		cfg.DefaultType = test.defaultType
+		cfg.InputType = test.inputType
+		jb, _ := newTestBeat(cfg)
+
+		fields := map[string]string{"MESSAGE": "hello"}
+		for k, v := range test.fields {
+			fields[k] = v
+		}
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: fields})
+		if ref.body["type"] != test.wantType || ref.body["input_type"] != test.wantInput {
+			t.Errorf("%s: got type %v and input_type %v, want %v and %v", test.name, ref.body["type"], ref.body["input_type"], test.wantType, test.wantInput)
+		}
+	}
+}
+
+func TestPublishModeCursorAdvance(t *testing.T) {
+	tests := []struct {
+		mode             string
+		guaranteed, sync bool
+		// whether a failed event leaves the pending queue, so it is never sent again
+		givenUp bool
+	}{
+		{config.PublishModeGuaranteed, true, false, false},
+		{config.PublishModeSync, false, true, true},
+		{config.PublishModeAsync, false, false, true},
+		{config.PublishModeDropIfFull, false, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.WriteCursorState = true
+			cfg.PublishMode = test.mode
+			jb, client := newTestBeat(cfg)
+			client.fail = true
+
+			_, ctx := publisher.MakeContext(jb.publishOptions(nil))
+			if ctx.Guaranteed != test.guaranteed || ctx.Sync != test.sync {
+				t.Errorf("got guaranteed %v and sync %v, want %v and %v", ctx.Guaranteed, ctx.Sync, test.guaranteed, test.sync)
+			}
+
+			ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: map[string]string{"MESSAGE": "hello"}})
+			go jb.publishBatch([]*eventReference{ref})
+
+			// in every mode the event goes to the pending queue and the cursor advances once
+			// the event is handed over to the publisher, not once it is acked
+			select {
+			case <-jb.pending:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the event was not added to the pending queue")
+			}
+			select {
+			case cursor := <-jb.cursorChan:
+				if cursor != "c1" {
+					t.Errorf("got cursor %s, want c1", cursor)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the cursor did not advance")
+			}
+
+			// only the guaranteed mode keeps the failed event pending to send it again
+			select {
+			case <-jb.completed.ch:
+				if !test.givenUp {
+					t.Error("the failed event left the pending queue")
+				}
+			default:
+				if test.givenUp {
+					t.Error("the failed event was kept in the pending queue")
+				}
+			}
+		})
+	}
+}
+
+func TestEventFromEntryRequireMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		require      bool
+		messageField string
+		fields       map[string]string
+		dropped      bool
+	}{
+		{"with message", true, "MESSAGE", map[string]string{"MESSAGE": "hello"}, false},
+		{"without message", true, "MESSAGE", map[string]string{"_PID": "1"}, true},
+		{"empty message", true, "MESSAGE", map[string]string{"MESSAGE": ""}, true},
+		{"not required", false, "MESSAGE", map[string]string{"_PID": "1"}, false},
+		{"message field", true, "APP_MESSAGE", map[string]string{"MESSAGE": "hello"}, true},
+	}
+	for _, test := range tests {
+		cfg := testConfig(t)
+		cfg.RequireMessage = test.require
+		cfg.MessageField = test.messageField
+		jb, _ := newTestBeat(cfg)
+
+		before := eventsNoMessage.Get()
+		ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", Fields: test.fields})
+		if (ref == nil) != test.dropped {
+			t.Errorf("%s: got dropped %v, want %v", test.name, ref == nil, test.dropped)
+		}
+		want := int64(0)
+		if test.dropped {
+			want = 1
+		}
+		if counted := eventsNoMessage.Get() - before; counted != want {
+			t.Errorf("%s: counted %d entries without a message, want %d", test.name, counted, want)
+		}
+	}
+}
+
+// lateClient acks the events asynchronously, possibly after Run returned
+type lateClient struct {
+	wg sync.WaitGroup
+}
+
+func (c *lateClient) Close() error { return nil }
+
+func (c *lateClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c *lateClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	_, ctx := publisher.MakeContext(opts)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		time.Sleep(time.Millisecond)
+		op.SigCompleted(ctx.Signal)
+	}()
+	return true
+}
+
+func TestStopWhilePublishing(t *testing.T) {
+	// following never ends on its own
+	followJournal = func(_ journal.EntrySource, stop <-chan struct{}, _ *journal.Catalog, _ *journal.ContextLines, _ time.Duration) <-chan *sdjournal.JournalEntry {
+		out := make(chan *sdjournal.JournalEntry)
+		go func() {
+			defer close(out)
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				case out <- &sdjournal.JournalEntry{Cursor: fmt.Sprintf("c%d", i), Fields: map[string]string{"MESSAGE": "hello"}}:
+				}
+			}
+		}()
+		return out
+	}
+	defer func() { followJournal = journal.Follow }()
+
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, workers := range []int{1, 4} {
+		for i := 0; i < 10; i++ {
+			cfg := testConfig(t)
+			cfg.WriteCursorState = true
+			cfg.CursorStateFile = filepath.Join(dir, "state")
+			cfg.WorkerCount = workers
+			jb, err := newJournalbeat(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &lateClient{}
+			jb.client = client
+
+			result := make(chan error, 1)
+			go func() { result <- jb.Run(&beat.Beat{Name: "journalbeat"}) }()
+			time.Sleep(time.Duration(i) * time.Millisecond)
+			jb.Stop()
+			// stopping twice is fine
+			jb.Stop()
+
+			select {
+			case <-result:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%d workers: Run did not return after Stop", workers)
+			}
+			// the acks after Run returned are dropped
+			client.wg.Wait()
+		}
+	}
+}
+
+func TestEventFromEntryRealtimeTimestampField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		want  common.MapStr
+	}{
+		{"default", "@realtime_timestamp", common.MapStr{"@realtime_timestamp": int64(1500000000123456)}},
+		{"renamed", "journal.realtime", common.MapStr{"journal": common.MapStr{"realtime": int64(1500000000123456)}}},
+		{"omitted", "", common.MapStr{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.RealtimeTimestampField = test.field
+			jb, _ := newTestBeat(cfg)
+
+			ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "c1", RealtimeTimestamp: 1500000000123456, Fields: map[string]string{"MESSAGE": "hello"}})
+			for _, key := range []string{"@realtime_timestamp", "journal"} {
+				got, _ := ref.body.GetValue(key)
+				want, _ := test.want.GetValue(key)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got %s %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+}
@@ -14,22 +14,11 @@
 
 package beater
 
-import "fmt"
-
-func (jb *Journalbeat) addKernel() error {
-	if len(jb.config.Units) > 0 && jb.config.Kernel {
-		err := jb.addMatchesForKernel()
-		if err != nil {
-			return fmt.Errorf("Adding filter for kernel failed: %v", err)
-		}
+// kernelTerms returns the filter term for the kernel logs, which are gathered
+// next to the units when units are provided
+func (r *journalReader) kernelTerms() []filterTerm {
+	if len(r.config.Units) > 0 && r.config.Kernel {
+		return []filterTerm{{"_TRANSPORT=kernel"}}
 	}
 	return nil
 }
-
-func (jb *Journalbeat) addMatchesForKernel() error {
-	err := jb.journal.AddMatch("_TRANSPORT=kernel")
-	if err != nil {
-		return err
-	}
-	return jb.journal.AddDisjunction()
-}
\ No newline at end of file
@@ -0,0 +1,245 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The journal filter is an expression of three levels, see sd_journal_add_match(3):
+// matches of different fields are combined with AND, matches of the same field with OR.
+// AddDisjunction combines such terms with OR and AddConjunction combines the
+// resulting disjunctions with AND.
+
+package beater
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// matcher is the part of the journal needed to assemble the filter
+type matcher interface {
+	AddMatch(match string) error
+	AddDisjunction() error
+	AddConjunction() error
+}
+
+// filterTerm is a set of FIELD=value matches which all have to match
+type filterTerm []string
+
+// filterGroup is a set of terms of which at least one has to match
+type filterGroup []filterTerm
+
+// addFilter adds the filter groups to the journal. All of the groups have to match:
+//
+//	(term1 OR term2 ...) AND (term3 OR ...) AND ...
+//
+// The calls are issued in the order of the groups and their terms. Every group but the
+// first is preceded by a conjunction and every term but the first of a group is preceded
+// by a disjunction.
+func addFilter(j matcher, groups []filterGroup) error {
+	for i, group := range groups {
+		if i > 0 {
+			if err := j.AddConjunction(); err != nil {
+				return err
+			}
+		}
+
+		for k, term := range group {
+			if k > 0 {
+				if err := j.AddDisjunction(); err != nil {
+					return err
+				}
+			}
+
+			for _, match := range term {
+				if err := j.AddMatch(match); err != nil {
+					return fmt.Errorf("match %s: %v", match, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// filterGroups returns the groups of the journal filter in this order:
+// - the selection: the units together with the kernel, the syslog identifiers and the patterns
+// - the transports
+// - the matches combined according to the matches mode
+// - the filters, each of them a term
+// - the priorities up to the max priority
+// - the boot if only a single boot is followed
+//
+// In the "or" filter mode the selection is a single group, as any of them is enough. In the "and"
+// filter mode the units and the kernel form one group, the identifiers another one and the match
+// patterns are grouped by their field, in the order the fields appear first.
+func (r *journalReader) filterGroups() ([]filterGroup, error) {
+	var groups []filterGroup
+
+	units, err := r.unitTerms()
+	if err != nil {
+		return nil, err
+	}
+	units = append(units, r.kernelTerms()...)
+
+	var identifiers filterGroup
+	for _, identifier := range r.config.Identifiers {
+		identifiers = append(identifiers, filterTerm{sdjournal.SD_JOURNAL_FIELD_SYSLOG_IDENTIFIER + "=" + identifier})
+	}
+
+	patterns, err := fieldGroups(r.config.MatchPatterns)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.config.FilterMode == config.FilterModeAnd {
+		for _, group := range append([]filterGroup{units, identifiers}, patterns...) {
+			if len(group) > 0 {
+				groups = append(groups, group)
+			}
+		}
+	} else {
+		// any unit, identifier or pattern is enough
+		selected := append(units, identifiers...)
+		for _, group := range patterns {
+			selected = append(selected, group...)
+		}
+		if len(selected) > 0 {
+			groups = append(groups, selected)
+		}
+	}
+
+	var transports filterGroup
+	for _, transport := range r.config.Transports {
+		transports = append(transports, filterTerm{sdjournal.SD_JOURNAL_FIELD_TRANSPORT + "=" + transport})
+	}
+	if len(transports) > 0 {
+		groups = append(groups, transports)
+	}
+
+	matches, err := matchesGroup(r.config.Matches, r.config.MatchesMode)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 {
+		logp.Info("Matching the journal entries on %s (mode: %s)", strings.Join(r.config.Matches, ", "), r.config.MatchesMode)
+		groups = append(groups, matches)
+	}
+
+	filters, err := filtersGroup(r.config.Filters)
+	if err != nil {
+		return nil, err
+	}
+	if len(filters) > 0 {
+		groups = append(groups, filters)
+	}
+
+	if r.config.MaxPriority != nil {
+		var priorities filterGroup
+		for priority := 0; priority <= *r.config.MaxPriority; priority++ {
+			priorities = append(priorities, filterTerm{fmt.Sprintf("%s=%d", sdjournal.SD_JOURNAL_FIELD_PRIORITY, priority)})
+		}
+		groups = append(groups, priorities)
+	}
+
+	boot, err := r.bootTerms()
+	if err != nil {
+		return nil, err
+	}
+	if len(boot) > 0 {
+		groups = append(groups, boot)
+	}
+
+	return groups, nil
+}
+
+// matchesGroup combines the FIELD=value matches into a single group. In the "or" mode every
+// match is a term of its own. In the "and" mode all matches form one term, so like with
+// journalctl matches of different fields have to match all while matches of the same field
+// are still alternatives.
+func matchesGroup(matches []string, mode string) (filterGroup, error) {
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var group filterGroup
+	var term filterTerm
+	for _, match := range matches {
+		if _, err := splitMatch(match); err != nil {
+			return nil, err
+		}
+		if mode == config.MatchesModeOr {
+			group = append(group, filterTerm{match})
+		} else {
+			term = append(term, match)
+		}
+	}
+	if len(term) > 0 {
+		group = append(group, term)
+	}
+
+	return group, nil
+}
+
+// filtersGroup turns the filters into a single group. Each filter is a term of its own, so the
+// matches of a filter have to match all while any filter is enough. The terms keep the order
+// of the filters and their matches, which makes the calls issued by addFilter deterministic.
+func filtersGroup(filters [][]string) (filterGroup, error) {
+	var group filterGroup
+	for _, filter := range filters {
+		if len(filter) == 0 {
+			continue
+		}
+		for _, match := range filter {
+			if _, err := splitMatch(match); err != nil {
+				return nil, err
+			}
+		}
+		group = append(group, filterTerm(filter))
+	}
+	return group, nil
+}
+
+// splitMatch splits a FIELD=value match into the field and the value
+func splitMatch(match string) ([]string, error) {
+	kv := strings.SplitN(match, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return nil, fmt.Errorf("Filtering pattern %s failed: should be FIELD=value", match)
+	}
+	return kv, nil
+}
+
+// fieldGroups groups FIELD=value matches by their field
+func fieldGroups(matches []string) ([]filterGroup, error) {
+	var groups []filterGroup
+	index := map[string]int{}
+
+	for _, match := range matches {
+		kv, err := splitMatch(match)
+		if err != nil {
+			return nil, err
+		}
+
+		i, ok := index[kv[0]]
+		if !ok {
+			i = len(groups)
+			index[kv[0]] = i
+			groups = append(groups, filterGroup{})
+		}
+		groups[i] = append(groups[i], filterTerm{match})
+	}
+
+	return groups, nil
+}
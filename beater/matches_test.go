@@ -0,0 +1,153 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mheese/journalbeat/config"
+)
+
+// recordingMatcher records the calls addFilter issues
+type recordingMatcher struct {
+	calls []string
+}
+
+func (m *recordingMatcher) AddMatch(match string) error {
+	m.calls = append(m.calls, match)
+	return nil
+}
+
+func (m *recordingMatcher) AddDisjunction() error {
+	m.calls = append(m.calls, "OR")
+	return nil
+}
+
+func (m *recordingMatcher) AddConjunction() error {
+	m.calls = append(m.calls, "AND")
+	return nil
+}
+
+func TestFilterCalls(t *testing.T) {
+	unit := []string{
+		"_SYSTEMD_UNIT=ssh.service", "OR",
+		"MESSAGE_ID=fc2e22bc6ee647b6b90729ab34a250b1", "_UID=0", "COREDUMP_UNIT=ssh.service", "OR",
+		"_PID=1", "UNIT=ssh.service", "OR",
+		"_UID=0", "OBJECT_SYSTEMD_UNIT=ssh.service",
+	}
+	calls := func(parts ...[]string) []string {
+		var all []string
+		for _, part := range parts {
+			all = append(all, part...)
+		}
+		return all
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		want   []string
+	}{
+		{"no filter", func(c *config.Config) {}, nil},
+		{"or mode", func(c *config.Config) {
+			c.Units = []string{"ssh"}
+			c.Identifiers = []string{"cron"}
+			c.MatchPatterns = []string{"A=1", "B=2", "A=3"}
+		}, calls(unit, []string{"OR", "_TRANSPORT=kernel", "OR", "SYSLOG_IDENTIFIER=cron", "OR", "A=1", "OR", "A=3", "OR", "B=2"})},
+		{"or mode without units", func(c *config.Config) {
+			c.Identifiers = []string{"cron", "sshd"}
+			c.MatchPatterns = []string{"A=1"}
+		}, []string{"SYSLOG_IDENTIFIER=cron", "OR", "SYSLOG_IDENTIFIER=sshd", "OR", "A=1"}},
+		{"and mode", func(c *config.Config) {
+			c.FilterMode = config.FilterModeAnd
+			c.Units = []string{"ssh"}
+			c.Identifiers = []string{"cron"}
+			c.MatchPatterns = []string{"A=1", "B=2", "A=3"}
+		}, calls(unit, []string{"OR", "_TRANSPORT=kernel", "AND", "SYSLOG_IDENTIFIER=cron", "AND", "A=1", "OR", "A=3", "AND", "B=2"})},
+		{"and mode without units", func(c *config.Config) {
+			c.FilterMode = config.FilterModeAnd
+			c.MatchPatterns = []string{"A=1", "B=2"}
+		}, []string{"A=1", "AND", "B=2"}},
+		{"further groups", func(c *config.Config) {
+			c.Identifiers = []string{"cron", "sshd"}
+			c.Transports = []string{config.TransportSyslog}
+			c.Matches = []string{"_COMM=sshd", "_UID=0"}
+			c.Filters = [][]string{{"C=1"}, {"D=2", "E=3"}}
+		}, []string{
+			"SYSLOG_IDENTIFIER=cron", "OR", "SYSLOG_IDENTIFIER=sshd",
+			"AND", "_TRANSPORT=syslog",
+			"AND", "_COMM=sshd", "_UID=0",
+			"AND", "C=1", "OR", "D=2", "E=3",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			test.modify(&cfg)
+			jb, _ := newTestBeat(cfg)
+
+			groups, err := jb.filterGroups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &recordingMatcher{}
+			if err := addFilter(m, groups); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.calls, test.want) {
+				t.Errorf("got calls\n%v\nwant\n%v", m.calls, test.want)
+			}
+		})
+	}
+}
+
+func TestFiltersGroup(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters [][]string
+		want    []string
+		err     bool
+	}{
+		{"none", nil, nil, false},
+		{"one filter", [][]string{{"_SYSTEMD_UNIT=ssh.service", "_UID=0"}}, []string{"_SYSTEMD_UNIT=ssh.service", "_UID=0"}, false},
+		{"any of several", [][]string{{"_SYSTEMD_UNIT=ssh.service", "PRIORITY=3"}, {"_TRANSPORT=kernel"}}, []string{
+			"_SYSTEMD_UNIT=ssh.service", "PRIORITY=3", "OR", "_TRANSPORT=kernel",
+		}, false},
+		{"empty filters are skipped", [][]string{{}, {"A=1"}, {}}, []string{"A=1"}, false},
+		{"invalid match", [][]string{{"A=1"}, {"no match"}}, nil, true},
+		{"match without field", [][]string{{"=1"}}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group, err := filtersGroup(test.filters)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want an error: %v", err, test.err)
+			}
+			var groups []filterGroup
+			if len(group) > 0 {
+				groups = append(groups, group)
+			}
+			m := &recordingMatcher{}
+			if err := addFilter(m, groups); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.calls, test.want) {
+				t.Errorf("got calls %v, want %v", m.calls, test.want)
+			}
+		})
+	}
+}
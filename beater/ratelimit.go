@@ -0,0 +1,122 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"time"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// tokenBucket allows rate events per second on average and bursts of up to burst events
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit config.RateLimit, now time.Time) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: limit.Rate, burst: burst, tokens: burst, last: now}
+}
+
+// allow takes a token from the bucket if there is one, after refilling it for the time passed
+func (b *tokenBucket) allow(now time.Time) bool {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idle reports whether the bucket has refilled by now, so it is no different from a new one
+func (b *tokenBucket) idle(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// sweepInterval is how often the rate limiter drops the buckets of units which went idle
+const sweepInterval = time.Minute
+
+// rateLimiter keeps a token bucket per value of the rate limited field. It is only used by
+// the event loop in Run, so it is not safe for concurrent use.
+type rateLimiter struct {
+	field        string
+	defaultLimit config.RateLimit
+	limits       map[string]config.RateLimit
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+}
+
+func newRateLimiter(cfg config.RateLimitConfig) *rateLimiter {
+	l := &rateLimiter{
+		field:        cfg.Field,
+		defaultLimit: cfg.Default,
+		limits:       map[string]config.RateLimit{},
+		buckets:      map[string]*tokenBucket{},
+		lastSweep:    time.Now(),
+	}
+	for _, limit := range cfg.Limits {
+		l.limits[limit.Value] = limit
+	}
+	return l
+}
+
+// allow reports whether the entry is within the limit of its unit. The entries of units
+// without a limit of their own share the default limit per unit, if any.
+func (l *rateLimiter) allow(entry *sdjournal.JournalEntry, now time.Time) bool {
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	key := entry.Fields[l.field]
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		limit, ok := l.limits[key]
+		if !ok {
+			limit = l.defaultLimit
+		}
+		if limit.Rate <= 0 {
+			return true
+		}
+		bucket = newTokenBucket(limit, now)
+		l.buckets[key] = bucket
+	}
+	return bucket.allow(now)
+}
+
+// sweep drops the full buckets, they are created again when their unit logs again. Without
+// it every unit ever seen, e.g. every transient unit, would keep its bucket forever.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.idle(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
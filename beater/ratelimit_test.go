@@ -0,0 +1,101 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func unitEntry(unit string) *sdjournal.JournalEntry {
+	return &sdjournal.JournalEntry{Fields: map[string]string{"_SYSTEMD_UNIT": unit}}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Field:   "_SYSTEMD_UNIT",
+		Default: config.RateLimit{Rate: 1, Burst: 2},
+		Limits: []config.RateLimit{
+			{Value: "fast.service", Rate: 10, Burst: 3},
+			{Value: "free.service", Rate: 0},
+		},
+	})
+	start := time.Now()
+
+	tests := []struct {
+		unit  string
+		after time.Duration
+		want  bool
+	}{
+		// the default burst, per unit
+		{"a.service", 0, true},
+		{"a.service", 0, true},
+		{"a.service", 0, false},
+		{"b.service", 0, true},
+		// a token per second
+		{"a.service", time.Second, true},
+		{"a.service", time.Second, false},
+		// a limit of its own
+		{"fast.service", 0, true},
+		{"fast.service", 0, true},
+		{"fast.service", 0, true},
+		{"fast.service", 0, false},
+		{"fast.service", 100 * time.Millisecond, true},
+		// no rate, no limit
+		{"free.service", 0, true},
+		{"free.service", 0, true},
+		{"free.service", 0, true},
+	}
+	for i, test := range tests {
+		if got := limiter.allow(unitEntry(test.unit), start.Add(test.after)); got != test.want {
+			t.Errorf("%d: allow(%s) after %v = %v, want %v", i, test.unit, test.after, got, test.want)
+		}
+	}
+}
+
+func TestRateLimiterSweepsIdleBuckets(t *testing.T) {
+	limiter := newRateLimiter(config.RateLimitConfig{
+		Field:   "_SYSTEMD_UNIT",
+		Default: config.RateLimit{Rate: 0.01, Burst: 1},
+		Limits:  []config.RateLimit{{Value: "busy.service", Rate: 1, Burst: 1}},
+	})
+	start := limiter.lastSweep
+
+	for _, unit := range []string{"a.service", "b.service", "busy.service"} {
+		limiter.allow(unitEntry(unit), start)
+	}
+	if len(limiter.buckets) != 3 {
+		t.Fatalf("got %d buckets, want 3", len(limiter.buckets))
+	}
+
+	// a.service and b.service are still refilling after a minute, busy.service refilled
+	// and logs again
+	limiter.allow(unitEntry("busy.service"), start.Add(sweepInterval))
+	if len(limiter.buckets) != 3 {
+		t.Fatalf("got %d buckets after the first sweep, want 3", len(limiter.buckets))
+	}
+	if limiter.allow(unitEntry("a.service"), start.Add(sweepInterval)) {
+		t.Error("a.service was allowed before its bucket refilled")
+	}
+
+	// all of them refilled
+	limiter.allow(unitEntry("c.service"), start.Add(200*time.Second))
+	if _, ok := limiter.buckets["c.service"]; !ok || len(limiter.buckets) != 1 {
+		t.Errorf("got buckets %v, want only c.service", limiter.buckets)
+	}
+}
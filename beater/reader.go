@@ -0,0 +1,49 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"sync"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// journalReader holds the state of reading a single journal: the journal with its filter and
+// read position, and the cursor store the cursor of the entries handed over is saved to.
+// Journalbeat reads its main journal with the reader it embeds and every journal source with a
+// reader of its own, while the entries of all of them are published by Journalbeat.
+type journalReader struct {
+	// config is the configuration of the journal, the main reader shares the one of Journalbeat
+	config *config.Config
+	// name is the name of the journal source, empty for the main journal
+	name string
+
+	// journalMu guards replacing the journal when it is reopened
+	journalMu sync.RWMutex
+	journal   *sdjournal.Journal
+	followErr error
+	// startCursor, or else startUsec, is where the journal was positioned at start up, so it is
+	// positioned there again if it is reopened before any entry was handed over
+	startCursor string
+	startUsec   uint64
+	// lastBootID is the boot ID of the last entry read, for the boot markers
+	lastBootID string
+
+	cursorStore cursorStore
+	cursorChan  chan string
+	// cursorLoop waits for writeCursorLoop to save the last cursor
+	cursorLoop sync.WaitGroup
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+
+	"github.com/elastic/beats/libbeat/cfgfile"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
+)
+
+// reloadLoop re-reads the configuration file on SIGHUP and hands it over to the follower of
+// the journal, which applies it between two entries
+func (jb *Journalbeat) reloadLoop(name string) {
+	defer jb.reporters.Done()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-jb.done:
+			return
+		case <-hup:
+		}
+
+		cfg, err := loadConfig(name)
+		if err != nil {
+			logp.Err("Reloading the configuration failed, keeping the current one: %v", err)
+			continue
+		}
+		logp.Info("Reloading the configuration")
+
+		select {
+		case <-jb.done:
+			return
+		case jb.reload <- cfg:
+		}
+	}
+}
+
+// loadConfig reads the configuration of the beat from the configuration file again
+func loadConfig(name string) (config.Config, error) {
+	raw, err := cfgfile.Load("")
+	if err != nil {
+		return config.DefaultConfig, err
+	}
+
+	name = strings.ToLower(name)
+	if !raw.HasField(name) {
+		cfg := config.DefaultConfig
+		// Validate sets the default maps, as Unpack would have done
+		return cfg, cfg.Validate()
+	}
+	sub, err := raw.Child(name, -1)
+	if err != nil {
+		return config.DefaultConfig, err
+	}
+	return unpackConfig(sub)
+}
+
+// unpackConfig unpacks the configuration of the beat and applies the logstash_compat preset,
+// the same way on start and on reload
+func unpackConfig(raw *common.Config) (config.Config, error) {
+	cfg := config.DefaultConfig
+	if err := raw.Unpack(&cfg); err != nil {
+		return cfg, fmt.Errorf("Error reading config file: %v", err)
+	}
+	if cfg.LogstashCompat {
+		cfg.ApplyLogstashCompat(raw)
+	}
+	return cfg, nil
+}
+
+// setFilterFields copies the fields of the journal filter, which are applied on reload
+// without reopening the journal
+func setFilterFields(dst, src *config.Config) {
+	dst.Units = src.Units
+	dst.Kernel = src.Kernel
+	dst.Identifiers = src.Identifiers
+	dst.Transports = src.Transports
+	dst.MatchPatterns = src.MatchPatterns
+	dst.FilterMode = src.FilterMode
+	dst.Matches = src.Matches
+	dst.MatchesMode = src.MatchesMode
+	dst.Filters = src.Filters
+	dst.MaxPriority = src.MaxPriority
+	dst.CurrentBootOnly = src.CurrentBootOnly
+	dst.BootOffset = src.BootOffset
+}
+
+// setJournalFields copies the fields selecting the journal, which reopen the journal on reload
+func setJournalFields(dst, src *config.Config) {
+	dst.JournalPaths = src.JournalPaths
+	dst.JournalNamespace = src.JournalNamespace
+	dst.NamespaceMode = src.NamespaceMode
+	dst.JournalScope = src.JournalScope
+}
+
+// applyReload applies the filter and the journal of the reloaded configuration. A new filter
+// replaces the matches of the open journal, a different journal is reopened. Either way reading
+// goes on after the entry at the cursor, or if no entry was handed over yet, from the position
+// the journal was positioned at on start up. If the reloaded configuration can not be applied, the
+// previous one is restored and an error is only returned if restoring it failed too.
+// The other fields of the configuration only change on restart.
+func (jb *Journalbeat) applyReload(cfg config.Config, cursor string) error {
+	var previous config.Config
+	setFilterFields(&previous, &jb.config)
+	setJournalFields(&previous, &jb.config)
+
+	reopen := !reflect.DeepEqual(cfg.JournalPaths, jb.config.JournalPaths) ||
+		cfg.JournalNamespace != jb.config.JournalNamespace ||
+		cfg.NamespaceMode != jb.config.NamespaceMode ||
+		cfg.JournalScope != jb.config.JournalScope
+
+	apply := func(cfg *config.Config) error {
+		setFilterFields(&jb.config, cfg)
+		setJournalFields(&jb.config, cfg)
+		if reopen || cursor == "" {
+			return jb.reopenJournal(cursor)
+		}
+		return jb.replaceFilter(cursor)
+	}
+
+	err := apply(&cfg)
+	if err == nil {
+		if reopen {
+			logp.Info("Reopened the journal with the reloaded configuration")
+		} else {
+			logp.Info("Replaced the journal filter with the reloaded one")
+		}
+		return nil
+	}
+
+	logp.Err("Applying the reloaded configuration failed, keeping the current one: %v", err)
+	if err = apply(&previous); err != nil {
+		return fmt.Errorf("Restoring the configuration failed: %v", err)
+	}
+	return nil
+}
+
+// replaceFilter replaces the matches of the open journal and moves the read pointer after the
+// entry at the cursor, as the matches only apply to the entries read after a seek
+func (r *journalReader) replaceFilter(cursor string) error {
+	r.journal.FlushMatches()
+
+	groups, err := r.filterGroups()
+	if err != nil {
+		return err
+	}
+	if err = addFilter(r.journal, groups); err != nil {
+		return fmt.Errorf("Adding the journal filter failed: %v", err)
+	}
+
+	if err = r.journal.SeekCursor(cursor); err != nil {
+		return fmt.Errorf("Could not seek to cursor %s: %v", cursor, err)
+	}
+	// the entry at the cursor has been handed over already, so skip it
+	if _, err = r.journal.Next(); err != nil {
+		return fmt.Errorf("Could not skip the entry at cursor %s: %v", cursor, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/mheese/journalbeat/config"
+)
+
+func TestUnpackConfigLogstashCompat(t *testing.T) {
+	mapping := map[string]string{"SYSLOG_IDENTIFIER": "program", "_PID": "pid", "_HOSTNAME": "logsource"}
+	tests := []struct {
+		name            string
+		yaml            string
+		inputType       string
+		metadata        string
+		cleanFieldNames bool
+		fieldMapping    map[string]string
+	}{
+		{
+			name:            "off",
+			yaml:            "logstash_compat: false",
+			inputType:       "journal",
+			metadata:        "",
+			cleanFieldNames: false,
+			fieldMapping:    map[string]string{},
+		},
+		{
+			name:            "preset",
+			yaml:            "logstash_compat: true",
+			inputType:       "log",
+			metadata:        "journal",
+			cleanFieldNames: true,
+			fieldMapping:    mapping,
+		},
+		{
+			name:            "overridden",
+			yaml:            "logstash_compat: true\ninput_type: journald\nclean_field_names: false\nmove_metadata_to_field: meta",
+			inputType:       "journald",
+			metadata:        "meta",
+			cleanFieldNames: false,
+			fieldMapping:    mapping,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := common.NewConfigWithYAML([]byte(test.yaml), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg, err := unpackConfig(raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.InputType != test.inputType {
+				t.Errorf("input_type: got %q, want %q", cfg.InputType, test.inputType)
+			}
+			if cfg.MoveMetadataLocation != test.metadata {
+				t.Errorf("move_metadata_to_field: got %q, want %q", cfg.MoveMetadataLocation, test.metadata)
+			}
+			if cfg.CleanFieldNames != test.cleanFieldNames {
+				t.Errorf("clean_field_names: got %v, want %v", cfg.CleanFieldNames, test.cleanFieldNames)
+			}
+			if len(cfg.FieldMapping) != 0 || len(test.fieldMapping) != 0 {
+				if !reflect.DeepEqual(cfg.FieldMapping, test.fieldMapping) {
+					t.Errorf("field_mapping: got %v, want %v", cfg.FieldMapping, test.fieldMapping)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyReloadWithoutCursor(t *testing.T) {
+	for _, position := range []string{config.SeekPositionHead, config.SeekPositionTail, config.SeekPositionRealtimeNow} {
+		t.Run(position, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.SeekPosition = position
+			jb, _ := newTestBeat(cfg)
+			if err := jb.initJournal(); err != nil {
+				t.Skipf("Could not open the journal: %v", err)
+			}
+			defer func() { _ = jb.journal.Close() }()
+			start := jb.startUsec
+
+			// no entry was handed over, so the reloaded filter applies from the start position
+			time.Sleep(2 * time.Millisecond)
+			reloaded := jb.config
+			reloaded.Identifiers = []string{"sshd"}
+			if err := jb.applyReload(reloaded, ""); err != nil {
+				t.Fatal(err)
+			}
+			if jb.startUsec != start {
+				t.Errorf("got start position %d, want the one of start up %d", jb.startUsec, start)
+			}
+			if !reflect.DeepEqual(jb.config.Identifiers, reloaded.Identifiers) {
+				t.Errorf("got identifiers %v, want %v", jb.config.Identifiers, reloaded.Identifiers)
+			}
+		})
+	}
+}
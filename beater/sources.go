@@ -0,0 +1,107 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// newSource sets up the reader of an additional journal source. The source inherits the
+// configuration of jb except for the paths, units and matches, and its cursor is stored next to
+// the cursor state file of jb. Its entries are followed and published by jb.
+func (jb *Journalbeat) newSource(source config.JournalSource) (*journalReader, error) {
+	cfg := jb.config
+	cfg.JournalPaths = source.Paths
+	cfg.JournalNamespace = ""
+	cfg.NamespaceMode = config.NamespaceModeSingle
+	cfg.Units = source.Units
+	cfg.Matches = source.Matches
+	cfg.CursorStore = config.CursorStoreFile
+	cfg.CursorStateFile = fmt.Sprintf("%s.%s", jb.config.CursorStateFile, source.Name)
+	cfg.CursorBackupFile = ""
+	cfg.CursorStateBackups = 0
+
+	src := &journalReader{config: &cfg, name: source.Name, cursorChan: make(chan string)}
+	var err error
+	if src.cursorStore, err = newCursorStore(src.config); err != nil {
+		return nil, fmt.Errorf("journal source %s: %v", source.Name, err)
+	}
+
+	if err = src.initJournal(); err != nil {
+		if src.journal != nil {
+			_ = src.journal.Close()
+		}
+		return nil, fmt.Errorf("journal source %s: %v", source.Name, err)
+	}
+	return src, nil
+}
+
+// followSources follows the journal of jb and of all sources. The entries of the sources are
+// tagged with the name of their source. The returned channel is closed once all of them ended,
+// so a source which fails does not stall the others.
+func (jb *Journalbeat) followSources() <-chan *sdjournal.JournalEntry {
+	if len(jb.sources) == 0 {
+		return jb.follow(jb.journalReader)
+	}
+
+	out := make(chan *sdjournal.JournalEntry)
+	var wg sync.WaitGroup
+	// once jb is stopped the entries are dropped until following ended, so the channel is only
+	// closed after all followers are done with their journals
+	forward := func(name string, entries <-chan *sdjournal.JournalEntry) {
+		defer wg.Done()
+		for entry := range entries {
+			if name != "" {
+				entry.Fields[journal.SD_JOURNAL_FIELD_SOURCE] = name
+			}
+			select {
+			case <-jb.done:
+			case out <- entry:
+			}
+		}
+	}
+
+	wg.Add(1 + len(jb.sources))
+	go forward("", jb.follow(jb.journalReader))
+	for _, src := range jb.sources {
+		go forward(src.name, jb.follow(src))
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// closeSources stops the sources once their entries are not followed anymore
+func (jb *Journalbeat) closeSources() {
+	for _, src := range jb.sources {
+		if src.journal != nil {
+			_ = src.journal.Close()
+		}
+		close(src.cursorChan)
+		src.cursorLoop.Wait()
+		if src.followErr != nil {
+			logp.Err("journal source %s: %v", src.name, src.followErr)
+		}
+	}
+}
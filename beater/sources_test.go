@@ -0,0 +1,75 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestNewSourceFails(t *testing.T) {
+	jb, _ := newTestBeat(testConfig(t))
+
+	path := filepath.Join(t.TempDir(), "missing")
+	_, err := jb.newSource(config.JournalSource{Name: "remote", Paths: []string{path}})
+	if err == nil || !strings.Contains(err.Error(), "journal source remote") {
+		t.Errorf("got %v, want an error of the journal source", err)
+	}
+}
+
+func TestSourceCursor(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WriteCursorState = true
+	jb, _ := newTestBeat(cfg)
+	src := &journalReader{config: &cfg, name: "remote", cursorChan: make(chan string, 1)}
+	jb.sources = []*journalReader{src}
+	jb.cursorChan = make(chan string, 1)
+
+	// the entries of a source are tagged and their cursor is saved by the source
+	ref := jb.eventFromEntry(&sdjournal.JournalEntry{Cursor: "s1", Fields: map[string]string{
+		"MESSAGE":                       "hello",
+		journal.SD_JOURNAL_FIELD_SOURCE: "remote",
+	}})
+	if ref.source != src || ref.body["journalbeat"] == nil {
+		t.Fatalf("got source %v and event %v, want the entry of the source", ref.source, ref.body)
+	}
+	go func() {
+		for range jb.pending {
+		}
+	}()
+	jb.published(ref)
+	select {
+	case cursor := <-src.cursorChan:
+		if cursor != "s1" {
+			t.Errorf("got cursor %s for the source, want s1", cursor)
+		}
+	default:
+		t.Error("the cursor was not handed to the source")
+	}
+	select {
+	case cursor := <-jb.cursorChan:
+		t.Errorf("got cursor %s for the main journal, want none", cursor)
+	default:
+	}
+	if jb.lastCursor != "" {
+		t.Errorf("got last cursor %s of the main journal, want none", jb.lastCursor)
+	}
+	close(jb.pending)
+}
@@ -15,8 +15,10 @@
 package beater
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -25,43 +27,280 @@ import (
 
 	"github.com/elastic/beats/libbeat/common"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
 )
 
-// eventSignal implements the op.Signaler interface
+// backpressurePollPeriod is how often the pending events are checked while reading is paused
+const backpressurePollPeriod = 100 * time.Millisecond
+
+// eventSignal implements the op.Signaler interface. Without guaranteed delivery a failed
+// event is given up, so it is completed like an acked one. A failed event is appended to the
+// dead letter file, if any.
 type eventSignal struct {
-	ev        *eventReference
-	completed chan<- *eventReference
+	ev             *eventReference
+	completed      *completedQueue
+	dropFailed     bool
+	deadLetterFile string
+}
+
+// batchSignal implements the op.Signaler interface for a batch of events
+type batchSignal struct {
+	evs            []*eventReference
+	completed      *completedQueue
+	dropFailed     bool
+	deadLetterFile string
+}
+
+// newEventSignal returns the signal of an event published with the publish mode
+func (jb *Journalbeat) newEventSignal(ref *eventReference) *eventSignal {
+	return &eventSignal{ev: ref, completed: jb.completed, dropFailed: jb.dropFailed(), deadLetterFile: jb.failedDeadLetterFile()}
+}
+
+// newBatchSignal returns the signal of a batch of events published with the publish mode
+func (jb *Journalbeat) newBatchSignal(refs []*eventReference) *batchSignal {
+	return &batchSignal{evs: refs, completed: jb.completed, dropFailed: jb.dropFailed(), deadLetterFile: jb.failedDeadLetterFile()}
+}
+
+// failedDeadLetterFile returns the dead letter file for the failed events, if they are kept
+func (jb *Journalbeat) failedDeadLetterFile() string {
+	if !jb.config.PendingQueue.DeadLetterFailed {
+		return ""
+	}
+	return jb.config.PendingQueue.DeadLetterFile
+}
+
+// completedQueue hands the acked events over to the pending queue. The publisher may still
+// signal events after Run ended, so the channel is only closed under the lock and the events
+// signaled afterwards are dropped. They were saved with the pending queue and are sent again.
+type completedQueue struct {
+	mu     sync.RWMutex
+	ch     chan *eventReference
+	closed bool
+}
+
+func newCompletedQueue(size uint16) *completedQueue {
+	return &completedQueue{ch: make(chan *eventReference, size)}
+}
+
+// add hands the acked event over unless the queue is closed
+func (q *completedQueue) add(ref *eventReference) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if !q.closed {
+		q.ch <- ref
+	}
+}
+
+// close closes the channel, the pending queue has to consume it until then
+func (q *completedQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
 }
 
 // eventReference is used as a reference to the event being sent
 type eventReference struct {
 	cursor string
 	body   common.MapStr
+	// journalCursor is the cursor of the journal entry the event was read from, if any
+	journalCursor string
+	// source is the reader of the journal source the entry was read from, if not the main journal
+	source *journalReader
+	// attempts is the number of times the event was loaded from the pending queue to be published
+	attempts int
+}
+
+// pendingQueueVersion is the version of the pending queue file which keeps the attempts
+const pendingQueueVersion = 2
+
+// pendingQueueFile is the content of the pending queue file. The first version of the file
+// was a plain map of the events by their cursor.
+type pendingQueueFile struct {
+	Version int                    `json:"version"`
+	Events  map[string]queuedEvent `json:"events"`
+}
+
+// queuedEvent is an event of the pending queue file
+type queuedEvent struct {
+	Attempts int           `json:"attempts"`
+	Event    common.MapStr `json:"event"`
+}
+
+// readPendingQueue reads the events of the pending queue file, of either version
+func readPendingQueue(r io.Reader) (map[string]queuedEvent, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	queued := map[string]queuedEvent{}
+	if _, ok := raw["version"]; ok {
+		if err := json.Unmarshal(raw["events"], &queued); err != nil {
+			return nil, err
+		}
+		return queued, nil
+	}
+
+	for cursor, data := range raw {
+		var event common.MapStr
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, err
+		}
+		queued[cursor] = queuedEvent{Event: event}
+	}
+	return queued, nil
+}
+
+// deadLetterMu serializes the writes to the dead letter file, the failed events are written by
+// the publisher
+var deadLetterMu sync.Mutex
+
+// writeDeadLetters appends the events to the dead letter file, one JSON object per line
+func writeDeadLetters(file string, events map[string]queuedEvent) error {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for cursor, queued := range events {
+		if err = enc.Encode(common.MapStr{"cursor": cursor, "attempts": queued.Attempts, "event": queued.Event}); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// writeFailedDeadLetters appends the failed events to the dead letter file together with the
+// time they failed at. The publisher does not tell why they failed.
+func writeFailedDeadLetters(file string, refs []*eventReference) {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logp.Err("Could not write the failed events to the dead letter file %s: %v", file, err)
+		return
+	}
+	defer f.Close()
+
+	failedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	enc := json.NewEncoder(f)
+	for _, ref := range refs {
+		if err = enc.Encode(common.MapStr{"cursor": ref.cursor, "failed_at": failedAt, "event": ref.body}); err != nil {
+			logp.Err("Could not write the failed events to the dead letter file %s: %v", file, err)
+			return
+		}
+	}
 }
 
 func (ref *eventSignal) Completed() {
-	ref.completed <- ref.ev
+	eventsAcked.Inc()
+	eventsPending.Dec()
+	ref.completed.add(ref.ev)
 }
 
 func (ref *eventSignal) Failed() {
+	eventsFailed.Inc()
 	logp.Warn("Failed to publish message with cursor %s", ref.ev.cursor)
+	if ref.deadLetterFile != "" {
+		writeFailedDeadLetters(ref.deadLetterFile, []*eventReference{ref.ev})
+	}
+	if ref.dropFailed {
+		eventsPending.Dec()
+		ref.completed.add(ref.ev)
+	}
 }
 
 func (ref *eventSignal) Canceled() {
 	logp.Debug("pendingqueue", "Publishing message with cursor %s was canceled", ref.ev.cursor)
 }
 
+func (ref *batchSignal) Completed() {
+	eventsAcked.Add(int64(len(ref.evs)))
+	eventsPending.Add(-int64(len(ref.evs)))
+	for _, ev := range ref.evs {
+		ref.completed.add(ev)
+	}
+}
+
+func (ref *batchSignal) Failed() {
+	eventsFailed.Add(int64(len(ref.evs)))
+	logp.Warn("Failed to publish %d messages starting with cursor %s", len(ref.evs), ref.evs[0].cursor)
+	if ref.deadLetterFile != "" {
+		writeFailedDeadLetters(ref.deadLetterFile, ref.evs)
+	}
+	if ref.dropFailed {
+		eventsPending.Add(-int64(len(ref.evs)))
+		for _, ev := range ref.evs {
+			ref.completed.add(ev)
+		}
+	}
+}
+
+func (ref *batchSignal) Canceled() {
+	logp.Debug("pendingqueue", "Publishing %d messages starting with cursor %s was canceled", len(ref.evs), ref.evs[0].cursor)
+}
+
+// pendingFull reports whether an event is dropped instead of waiting for the pending events,
+// as the drop_if_full publish mode does once max_pending events are waiting to be acked
+func (jb *Journalbeat) pendingFull() bool {
+	return jb.config.PublishMode == config.PublishModeDropIfFull && jb.config.MaxPending > 0 &&
+		eventsPending.Get() >= int64(jb.config.MaxPending)
+}
+
+// waitForPending applies backpressure: once max_pending events are waiting to be acked no
+// further events are read until the pending events dropped to half of it. While Stop drains
+// the pending events nothing is read anymore. It returns false if Journalbeat was stopped
+// while waiting.
+func (jb *Journalbeat) waitForPending() bool {
+	select {
+	case <-jb.draining:
+		<-jb.done
+		return false
+	default:
+	}
+
+	if jb.config.MaxPending == 0 || eventsPending.Get() < int64(jb.config.MaxPending) {
+		return true
+	}
+
+	logp.Warn("%d events are pending, pausing reading the journal", eventsPending.Get())
+	backpressure.Set(1)
+	defer backpressure.Set(0)
+
+	lowWater := int64(jb.config.MaxPending / 2)
+	ticker := time.NewTicker(backpressurePollPeriod)
+	defer ticker.Stop()
+	for eventsPending.Get() > lowWater {
+		select {
+		case <-jb.done:
+			return false
+		case <-ticker.C:
+		}
+	}
+
+	logp.Info("%d events are pending, resuming reading the journal", eventsPending.Get())
+	return true
+}
+
 // managePendingQueueLoop runs the loop which manages the set of events waiting to be acked
 func (jb *Journalbeat) managePendingQueueLoop() {
-	jb.wg.Add(1)
 	defer jb.wg.Done()
-	pending := map[string]common.MapStr{}
-	completed := map[string]common.MapStr{}
+	pending := map[string]*eventReference{}
+	completed := map[string]*eventReference{}
 	queueChanged := false
 
 	// diff returns the difference between this map and the other.
-	diff := func(this, other map[string]common.MapStr) map[string]common.MapStr {
-		result := map[string]common.MapStr{}
+	diff := func(this, other map[string]*eventReference) map[string]*eventReference {
+		result := map[string]*eventReference{}
 		for k, v := range this {
 			if _, ok := other[k]; !ok {
 				result[k] = v
@@ -71,19 +310,42 @@ func (jb *Journalbeat) managePendingQueueLoop() {
 	}
 
 	// flush saves the map[string]common.MapStr to the JSON file on disk
-	flush := func(source map[string]common.MapStr, dest string) error {
+	flush := func(source map[string]*eventReference, dest string) error {
+		if dest == "" {
+			return nil
+		}
+
+		queue := pendingQueueFile{Version: pendingQueueVersion, Events: make(map[string]queuedEvent, len(source))}
+		for cursor, ref := range source {
+			queue.Events[cursor] = queuedEvent{Attempts: ref.attempts, Event: ref.body}
+		}
+
 		tempFile, err := ioutil.TempFile(filepath.Dir(dest), fmt.Sprintf(".%s", filepath.Base(dest)))
 		if err != nil {
 			return err
 		}
 
-		if err = json.NewEncoder(tempFile).Encode(source); err != nil {
+		var w io.Writer = tempFile
+		var gz *gzip.Writer
+		if jb.config.PendingQueue.Compression == config.PendingQueueCompressionGzip {
+			gz = gzip.NewWriter(tempFile)
+			w = gz
+		}
+
+		if err = json.NewEncoder(w).Encode(queue); err != nil {
 			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
 			return err
 		}
+		if gz != nil {
+			if err = gz.Close(); err != nil {
+				_ = tempFile.Close()
+				_ = os.Remove(tempFile.Name())
+				return err
+			}
+		}
 
-		_ = tempFile.Close()
-		return os.Rename(tempFile.Name(), dest)
+		return replaceFile(tempFile, dest, jb.config.DurableWrites)
 	}
 
 	// on exit fully consume both queues and flush to disk the pending queue
@@ -94,14 +356,14 @@ func (jb *Journalbeat) managePendingQueueLoop() {
 		go func() {
 			defer wg.Done()
 			for evRef := range jb.pending {
-				pending[evRef.cursor] = evRef.body
+				pending[evRef.cursor] = evRef
 			}
 		}()
 
 		go func() {
 			defer wg.Done()
-			for evRef := range jb.completed {
-				completed[evRef.cursor] = evRef.body
+			for evRef := range jb.completed.ch {
+				completed[evRef.cursor] = evRef
 			}
 		}()
 		wg.Wait()
@@ -112,7 +374,21 @@ func (jb *Journalbeat) managePendingQueueLoop() {
 		}
 	}()
 
-	// flush the pending queue to disk periodically
+	// unflushed counts the events added to the pending queue since the last flush
+	unflushed := 0
+	flushPending := func() {
+		result := diff(pending, completed)
+		if err := flush(result, jb.config.PendingQueue.File); err != nil {
+			logp.Err("error writing %s: %s", jb.config.PendingQueue.File, err)
+		}
+		pending = result
+		queueChanged = false
+		unflushed = 0
+		completed = map[string]*eventReference{}
+	}
+
+	// flush the pending queue to disk periodically, and during a burst already once max unflushed
+	// events were added
 	tick := time.Tick(jb.config.PendingQueue.FlushPeriod)
 	for {
 		select {
@@ -120,71 +396,159 @@ func (jb *Journalbeat) managePendingQueueLoop() {
 			return
 		case p, ok := <-jb.pending:
 			if ok {
-				pending[p.cursor] = p.body
+				pending[p.cursor] = p
 				queueChanged = true
+				if unflushed++; jb.config.PendingQueue.MaxUnflushed > 0 && unflushed >= jb.config.PendingQueue.MaxUnflushed {
+					logp.Debug("pendingqueue", "%d events were added since the last flush, flushing the pending queue", unflushed)
+					flushPending()
+				}
 			}
-		case c, ok := <-jb.completed:
+		case c, ok := <-jb.completed.ch:
 			if ok {
-				completed[c.cursor] = c.body
+				completed[c.cursor] = c
 				queueChanged = true
+				jb.republished.remove(c.cursor)
 			}
 		case <-tick:
 			if !queueChanged {
 				logp.Debug("pendingqueue", "Pending queue did not change")
 				continue
 			}
-			result := diff(pending, completed)
-			if err := flush(result, jb.config.PendingQueue.File); err != nil {
-				logp.Err("error writing %s: %s", jb.config.PendingQueue.File, err)
-			}
-			pending = result
-			queueChanged = false
-			completed = map[string]common.MapStr{}
+			flushPending()
 		}
 	}
 }
 
 // writeCursorLoop runs the loop which flushes the current cursor position to a file
-func (jb *Journalbeat) writeCursorLoop() {
-	jb.wg.Add(1)
-	defer jb.wg.Done()
+func (r *journalReader) writeCursorLoop() {
+	defer r.cursorLoop.Done()
 
-	var cursor string
-	saveCursorState := func(cursor string) {
-		if cursor == "" {
-			return
+	var cursor, lastSaved string
+	saveCursorState := func(store cursorStore, cursor string) bool {
+		if err := store.Save(cursor); err != nil {
+			logp.Err("Could not save cursor to the %s: %v, cursor: %s", store, err, cursor)
+			return false
 		}
+		cursorWrites.Inc()
+		return true
+	}
 
-		tempFile, err := ioutil.TempFile(filepath.Dir(jb.config.CursorStateFile), fmt.Sprintf(".%s", filepath.Base(jb.config.CursorStateFile)))
-		if err != nil {
-			logp.Err("Could not create cursor state file: %v", err)
+	// saveCursor writes the cursor to the cursor store. The writes to the backup file are staggered:
+	// it receives the cursor previously written to the state file, so a partial write can never
+	// corrupt both files at once.
+	saveCursor := func(cursor string) {
+		if cursor == "" || cursor == lastSaved {
 			return
 		}
 
-		if _, err = tempFile.WriteString(cursor); err != nil {
-			_ = tempFile.Close()
-			logp.Err("Could not write to cursor state file: %v, cursor: %s", err, cursor)
-			return
+		if r.config.CursorBackupFile != "" && lastSaved != "" {
+			saveCursorState(&fileCursorStore{file: r.config.CursorBackupFile, durable: r.config.CursorFsync}, lastSaved)
 		}
-		_ = tempFile.Close()
-		if err := os.Rename(tempFile.Name(), jb.config.CursorStateFile); err != nil {
-			logp.Err("Could not save cursor to the state file: %v, cursor: %s", err, cursor)
-			return
+
+		// shift the rotated state files, the state file itself becomes the first one
+		if r.config.CursorStateBackups > 0 && lastSaved != "" {
+			for i := r.config.CursorStateBackups; i > 1; i-- {
+				if err := os.Rename(cursorStateBackup(r.config.CursorStateFile, i-1), cursorStateBackup(r.config.CursorStateFile, i)); err != nil && !os.IsNotExist(err) {
+					logp.Err("Could not rotate the cursor state file: %v", err)
+				}
+			}
+			if err := os.Rename(r.config.CursorStateFile, cursorStateBackup(r.config.CursorStateFile, 1)); err != nil && !os.IsNotExist(err) {
+				logp.Err("Could not rotate the cursor state file: %v", err)
+			}
+		}
+
+		if saveCursorState(r.cursorStore, cursor) {
+			lastSaved = cursor
 		}
 	}
 
 	// save cursor for the last time when stop signal caught
-	// Saving the cursor through defer guarantees that the jb.cursorChan has been fully consumed
+	// Saving the cursor through defer guarantees that the r.cursorChan has been fully consumed
 	// and we are writing the cursor of the last message published.
-	defer func() { saveCursorState(cursor) }()
+	defer func() { saveCursor(cursor) }()
+
+	tick := time.Tick(r.config.CursorFlushPeriod)
 
-	tick := time.Tick(jb.config.CursorFlushPeriod)
+	// the debounce timer saves the cursor once the updates went quiet for the debounce period,
+	// so the newest cursor does not wait for the next tick after a burst
+	var debounce <-chan time.Time
+	debounceTimer := time.NewTimer(r.config.CursorDebounce)
+	debounceTimer.Stop()
+	if r.config.CursorDebounce > 0 {
+		debounce = debounceTimer.C
+	}
 
-	for cursor = range jb.cursorChan {
+	for {
 		select {
-		case <-tick:
-			saveCursorState(cursor)
-		default:
+		case c, ok := <-r.cursorChan:
+			if !ok {
+				return
+			}
+			cursor = c
+
+			if debounce != nil {
+				if !debounceTimer.Stop() {
+					select {
+					case <-debounceTimer.C:
+					default:
+					}
+				}
+				debounceTimer.Reset(r.config.CursorDebounce)
+			}
+
+			select {
+			case <-tick:
+				saveCursor(cursor)
+			default:
+			}
+		case <-debounce:
+			saveCursor(cursor)
 		}
 	}
 }
+
+// cursorStateBackup returns the name of the i-th rotated cursor state file
+func cursorStateBackup(file string, i int) string {
+	return fmt.Sprintf("%s.%d", file, i)
+}
+
+// syncFile is the part of a file needed by replaceFile
+type syncFile interface {
+	Name() string
+	Sync() error
+	Close() error
+}
+
+// openDir opens the directory synced by replaceFile
+var openDir = func(name string) (syncFile, error) {
+	return os.Open(name)
+}
+
+// replaceFile closes the written temp file and renames it to dest. With durable writes the
+// temp file is synced before the rename and the directory after it, so the new content
+// survives a power loss and not only a crash of journalbeat.
+func replaceFile(tempFile syncFile, dest string, durable bool) error {
+	if durable {
+		if err := tempFile.Sync(); err != nil {
+			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
+			return err
+		}
+	}
+	_ = tempFile.Close()
+
+	if err := os.Rename(tempFile.Name(), dest); err != nil {
+		_ = os.Remove(tempFile.Name())
+		return err
+	}
+	if !durable {
+		return nil
+	}
+
+	dir, err := openDir(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
@@ -0,0 +1,459 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+)
+
+// recordingFile records the calls on a file in a log shared with other files
+type recordingFile struct {
+	name    string
+	log     *[]string
+	syncErr error
+}
+
+func (f *recordingFile) Name() string {
+	return f.name
+}
+
+func (f *recordingFile) Sync() error {
+	*f.log = append(*f.log, "sync "+filepath.Base(f.name))
+	return f.syncErr
+}
+
+func (f *recordingFile) Close() error {
+	*f.log = append(*f.log, "close "+filepath.Base(f.name))
+	return nil
+}
+
+// recordDirs replaces openDir with one returning recording directories and returns the
+// restore func
+func recordDirs(log *[]string) func() {
+	saved := openDir
+	openDir = func(name string) (syncFile, error) {
+		*log = append(*log, "open "+filepath.Base(name))
+		return &recordingFile{name: name, log: log}, nil
+	}
+	return func() { openDir = saved }
+}
+
+func TestReplaceFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		durable  bool
+		syncErr  error
+		destDir  bool
+		want     []string
+		replaced bool
+	}{
+		{"not durable", false, nil, false, []string{"close temp"}, true},
+		{"durable", true, nil, false, []string{"sync temp", "close temp", "open dir", "sync dir", "close dir"}, true},
+		{"failed sync", true, errors.New("sync failed"), false, []string{"sync temp", "close temp"}, false},
+		// a directory in place of the destination makes the rename fail
+		{"failed rename", false, nil, true, []string{"close temp"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "journalbeat-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			dir := filepath.Join(tmp, "dir")
+			if err := os.Mkdir(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			temp, dest := filepath.Join(dir, "temp"), filepath.Join(dir, "dest")
+			if err := ioutil.WriteFile(temp, []byte("new"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if test.destDir {
+				if err := os.MkdirAll(filepath.Join(dest, "child"), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var log []string
+			defer recordDirs(&log)()
+			err = replaceFile(&recordingFile{name: temp, log: &log, syncErr: test.syncErr}, dest, test.durable)
+			if (err != nil) == test.replaced {
+				t.Errorf("got error %v, want an error %v", err, !test.replaced)
+			}
+			// the temporary file never stays behind
+			if _, err := os.Stat(temp); !os.IsNotExist(err) {
+				t.Errorf("the temporary file was left behind: %v", err)
+			}
+			if !reflect.DeepEqual(log, test.want) {
+				t.Errorf("got calls %v, want %v", log, test.want)
+			}
+
+			content, err := ioutil.ReadFile(dest)
+			if test.replaced && (err != nil || string(content) != "new") {
+				t.Errorf("dest not replaced: %q, %v", content, err)
+			}
+			if !test.replaced && !test.destDir && !os.IsNotExist(err) {
+				t.Errorf("dest replaced although syncing failed: %q, %v", content, err)
+			}
+		})
+	}
+}
+
+// waitForFile waits for the file to contain want
+func waitForFile(t *testing.T, file, want string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, _ := ioutil.ReadFile(file)
+		if string(got) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s contains %q, want %q", filepath.Base(file), got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// cursorTestBeat returns a Journalbeat writing the cursor state to dir, only on debounce
+// and on stop
+func cursorTestBeat(t *testing.T, dir string) *Journalbeat {
+	cfg := testConfig(t)
+	cfg.WriteCursorState = true
+	cfg.CursorStateFile = filepath.Join(dir, "state")
+	cfg.CursorFlushPeriod = time.Hour
+	cfg.CursorDebounce = 10 * time.Millisecond
+	jb, _ := newTestBeat(cfg)
+	return jb
+}
+
+func TestWriteCursorLoopStaggersBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jb := cursorTestBeat(t, dir)
+	jb.config.CursorBackupFile = filepath.Join(dir, "backup")
+	jb.cursorLoop.Add(1)
+	go jb.writeCursorLoop()
+
+	// the backup file always gets the cursor saved before
+	jb.cursorChan <- "c1"
+	waitForFile(t, jb.config.CursorStateFile, "c1")
+	if _, err := os.Stat(jb.config.CursorBackupFile); !os.IsNotExist(err) {
+		t.Errorf("the backup file was written with the first cursor: %v", err)
+	}
+
+	jb.cursorChan <- "c2"
+	waitForFile(t, jb.config.CursorStateFile, "c2")
+	waitForFile(t, jb.config.CursorBackupFile, "c1")
+
+	jb.cursorChan <- "c3"
+	close(jb.cursorChan)
+	jb.cursorLoop.Wait()
+	waitForFile(t, jb.config.CursorStateFile, "c3")
+	waitForFile(t, jb.config.CursorBackupFile, "c2")
+}
+
+func TestWriteCursorLoopDebounce(t *testing.T) {
+	tests := []struct {
+		name     string
+		debounce time.Duration
+		want     string
+	}{
+		// without a debounce the cursor waits for the next tick, an hour away
+		{"without debounce", 0, ""},
+		{"with debounce", 10 * time.Millisecond, "c2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "journalbeat")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			jb := cursorTestBeat(t, dir)
+			jb.config.CursorDebounce = test.debounce
+			jb.cursorLoop.Add(1)
+			go jb.writeCursorLoop()
+
+			jb.cursorChan <- "c1"
+			jb.cursorChan <- "c2"
+			time.Sleep(100 * time.Millisecond)
+			got, _ := ioutil.ReadFile(jb.config.CursorStateFile)
+			if string(got) != test.want {
+				t.Errorf("got cursor %q after the updates went quiet, want %q", got, test.want)
+			}
+
+			// the last cursor is saved on stop either way
+			close(jb.cursorChan)
+			jb.cursorLoop.Wait()
+			waitForFile(t, jb.config.CursorStateFile, "c2")
+		})
+	}
+}
+
+func TestFileCursorStoreFsync(t *testing.T) {
+	tests := []struct {
+		fsync bool
+		want  []string
+	}{
+		{false, nil},
+		// the directory is synced after the rename, so the new name survives a power loss
+		{true, []string{"open cursors", "sync cursors", "close cursors"}},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "journalbeat")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err = os.Mkdir(filepath.Join(dir, "cursors"), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := testConfig(t)
+		cfg.CursorStateFile = filepath.Join(dir, "cursors", "state")
+		cfg.CursorFsync = test.fsync
+		store, err := newCursorStore(&cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var log []string
+		restore := recordDirs(&log)
+		err = store.Save("c1")
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(log, test.want) {
+			t.Errorf("cursor_fsync %v: got calls %v, want %v", test.fsync, log, test.want)
+		}
+		if cursor, err := store.Load(); cursor != "c1" || err != nil {
+			t.Errorf("cursor_fsync %v: loaded %q, %v, want c1", test.fsync, cursor, err)
+		}
+	}
+}
+
+func TestCompletedQueueDropsAfterClose(t *testing.T) {
+	q := newCompletedQueue(1)
+	q.add(&eventReference{cursor: "c1"})
+	q.close()
+	// neither a late ack nor closing again panics
+	q.add(&eventReference{cursor: "c2"})
+	q.close()
+
+	var cursors []string
+	for ref := range q.ch {
+		cursors = append(cursors, ref.cursor)
+	}
+	if !reflect.DeepEqual(cursors, []string{"c1"}) {
+		t.Errorf("got %v, want only the event added before closing", cursors)
+	}
+}
+
+// readPendingQueueFile reads the events of an uncompressed pending queue file
+func readPendingQueueFile(t *testing.T, file string) map[string]queuedEvent {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	queued, err := readPendingQueue(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queued
+}
+
+func TestPendingQueueMaxUnflushed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := testConfig(t)
+	cfg.PendingQueue.File = filepath.Join(dir, "pending")
+	cfg.PendingQueue.FlushPeriod = time.Hour
+	cfg.PendingQueue.MaxUnflushed = 2
+	jb, _ := newTestBeat(cfg)
+	jb.wg.Add(1)
+	go jb.managePendingQueueLoop()
+
+	jb.pending <- &eventReference{cursor: "c1", body: common.MapStr{"message": "one"}}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(cfg.PendingQueue.File); !os.IsNotExist(err) {
+		t.Fatalf("the pending queue was flushed before max unflushed events were added: %v", err)
+	}
+
+	// the second event reaches max unflushed, well before the tick
+	jb.pending <- &eventReference{cursor: "c2", body: common.MapStr{"message": "two"}}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(cfg.PendingQueue.File); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the pending queue was not flushed once max unflushed events were added")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if queued := readPendingQueueFile(t, cfg.PendingQueue.File); len(queued) != 2 {
+		t.Errorf("got %d events in the pending queue, want 2", len(queued))
+	}
+
+	close(jb.done)
+	jb.closeQueues()
+	jb.wg.Wait()
+}
+
+func TestReadPendingQueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]queuedEvent
+		wantErr bool
+	}{
+		{
+			name:    "version 1",
+			content: `{"c1": {"message": "one"}, "c2": {"message": "two"}}`,
+			want: map[string]queuedEvent{
+				"c1": {Event: common.MapStr{"message": "one"}},
+				"c2": {Event: common.MapStr{"message": "two"}},
+			},
+		},
+		{
+			name:    "version 2",
+			content: `{"version": 2, "events": {"c1": {"attempts": 3, "event": {"message": "one"}}}}`,
+			want: map[string]queuedEvent{
+				"c1": {Attempts: 3, Event: common.MapStr{"message": "one"}},
+			},
+		},
+		{
+			name:    "empty version 1",
+			content: `{}`,
+			want:    map[string]queuedEvent{},
+		},
+		{
+			name:    "invalid",
+			content: `{"c1": "one"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := readPendingQueue(strings.NewReader(test.content))
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dead")
+	// the letters are appended, one JSON object per line
+	for _, cursor := range []string{"c1", "c2"} {
+		events := map[string]queuedEvent{cursor: {Attempts: 4, Event: common.MapStr{"message": cursor}}}
+		if err := writeDeadLetters(file, events); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d dead letters, want 2", len(lines))
+	}
+	for i, cursor := range []string{"c1", "c2"} {
+		var letter struct {
+			Cursor   string        `json:"cursor"`
+			Attempts int           `json:"attempts"`
+			Event    common.MapStr `json:"event"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.Cursor != cursor || letter.Attempts != 4 || letter.Event["message"] != cursor {
+			t.Errorf("got dead letter %+v, want cursor %s after 4 attempts", letter, cursor)
+		}
+	}
+}
+
+func TestWriteFailedDeadLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "dead")
+	writeFailedDeadLetters(file, []*eventReference{
+		{cursor: "c1", body: common.MapStr{"message": "one"}},
+	})
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var letter struct {
+		Cursor   string        `json:"cursor"`
+		FailedAt string        `json:"failed_at"`
+		Event    common.MapStr `json:"event"`
+	}
+	if err := json.Unmarshal(data, &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Cursor != "c1" || letter.Event["message"] != "one" {
+		t.Errorf("got dead letter %+v, want the failed event with cursor c1", letter)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, letter.FailedAt); err != nil {
+		t.Errorf("failed_at is not a timestamp: %v", err)
+	}
+}
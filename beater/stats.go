@@ -0,0 +1,102 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/elastic/beats/libbeat/monitoring"
+)
+
+// counters of the events passing through Journalbeat, they are exposed in the
+// journalbeat namespace of the monitoring registry, which is logged with the
+// internal metrics and published via expvar for the -httpprof endpoint
+var (
+	registry = monitoring.Default.NewRegistry("journalbeat", monitoring.PublishExpvar)
+
+	eventsRead      = monitoring.NewInt(registry, "events.read")
+	eventsDropped   = monitoring.NewInt(registry, "events.dropped")
+	eventsLimited   = monitoring.NewInt(registry, "events.rate_limited")
+	eventsNoMessage = monitoring.NewInt(registry, "events.dropped_no_message")
+	eventsPublished = monitoring.NewInt(registry, "events.published")
+	eventsAcked     = monitoring.NewInt(registry, "events.acked")
+	eventsFailed    = monitoring.NewInt(registry, "events.failed")
+	eventsPending   = monitoring.NewInt(registry, "events.pending")
+	cursorWrites    = monitoring.NewInt(registry, "cursor.writes")
+	cursorGaps      = monitoring.NewInt(registry, "cursor.gaps")
+	seekFailures    = monitoring.NewInt(registry, "seek.failures")
+	forwardDropped  = monitoring.NewInt(registry, "forward.dropped")
+	journalUsage    = monitoring.NewInt(registry, "journal.usage_bytes")
+	// backpressure is 1 while reading the journal is paused because of max_pending
+	backpressure = monitoring.NewInt(registry, "backpressure")
+)
+
+// stats returns the current counters together with the pending depth and the disk usage of the journal
+func (jb *Journalbeat) stats() common.MapStr {
+	stats := common.MapStr{
+		"read":      eventsRead.Get(),
+		"published": eventsPublished.Get(),
+		"failed":    eventsFailed.Get(),
+		"pending":   eventsPending.Get(),
+	}
+
+	jb.journalMu.RLock()
+	defer jb.journalMu.RUnlock()
+	if jb.journal == nil {
+		return stats
+	}
+	if usage, err := jb.journal.GetUsage(); err == nil {
+		stats["usage"] = usage
+	}
+	return stats
+}
+
+// reportUsageLoop reports the disk usage of the journal every usage report period
+func (jb *Journalbeat) reportUsageLoop() {
+	defer jb.reporters.Done()
+
+	ticker := time.NewTicker(jb.config.UsageReportPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-jb.done:
+			return
+		case <-ticker.C:
+		}
+
+		jb.journalMu.RLock()
+		if jb.journal == nil {
+			jb.journalMu.RUnlock()
+			continue
+		}
+		usage, err := jb.journal.GetUsage()
+		jb.journalMu.RUnlock()
+		if err != nil {
+			logp.Warn("Could not get the disk usage of the journal: %v", err)
+			continue
+		}
+		journalUsage.Set(int64(usage))
+
+		if jb.config.EmitUsageEvents {
+			jb.client.PublishEvent(common.MapStr{
+				"@timestamp": common.Time(time.Now()),
+				"type":       "journal_usage",
+				"journal":    common.MapStr{"usage_bytes": usage},
+			})
+		}
+	}
+}
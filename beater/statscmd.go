@@ -0,0 +1,91 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// printStats is the -stats flag. libbeat 5.6 has no subcommands, so unlike journalctl --disk-usage
+// the statistics are printed by starting journalbeat with the flag.
+var printStats = flag.Bool("stats", false, "Print the statistics of the journal and exit")
+
+// writeJournalStats writes the disk usage of the journal, the timestamps of the oldest and the
+// newest entry matching the filter and the filter itself. It moves the read pointer.
+func (jb *Journalbeat) writeJournalStats(w io.Writer) error {
+	usage, err := jb.journal.GetUsage()
+	if err != nil {
+		return fmt.Errorf("Could not get the disk usage of the journal: %v", err)
+	}
+	fmt.Fprintf(w, "Disk usage:   %d bytes\n", usage)
+
+	oldest, err := jb.edgeEntry(jb.journal.SeekHead, jb.journal.Next)
+	if err != nil {
+		return fmt.Errorf("Could not read the oldest entry: %v", err)
+	}
+	fmt.Fprintf(w, "Oldest entry: %s\n", oldest)
+
+	newest, err := jb.edgeEntry(jb.journal.SeekTail, jb.journal.Previous)
+	if err != nil {
+		return fmt.Errorf("Could not read the newest entry: %v", err)
+	}
+	fmt.Fprintf(w, "Newest entry: %s\n", newest)
+
+	groups, err := jb.filterGroups()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "Filter:")
+	if len(groups) == 0 {
+		fmt.Fprintln(w, "  none, all entries are read")
+	}
+	for _, group := range groups {
+		terms := make([]string, 0, len(group))
+		for _, term := range group {
+			terms = append(terms, strings.Join(term, " AND "))
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(terms, " OR "))
+	}
+	return nil
+}
+
+// edgeEntry seeks and moves to the next entry in the given direction and describes it by its
+// realtime timestamp and its boot, or returns "none" if there is no entry
+func (jb *Journalbeat) edgeEntry(seek func() error, move func() (uint64, error)) (string, error) {
+	if err := seek(); err != nil {
+		return "", err
+	}
+	n, err := move()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "none", nil
+	}
+
+	usec, err := jb.journal.GetRealtimeUsec()
+	if err != nil {
+		return "", err
+	}
+	_, bootID, err := jb.journal.GetMonotonicUsecBootID()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s (boot %s)", time.Unix(0, int64(usec)*1000).Format(time.RFC3339Nano), bootID), nil
+}
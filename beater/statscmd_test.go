@@ -0,0 +1,52 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestWriteJournalStats(t *testing.T) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		t.Skipf("Could not open the journal: %v", err)
+	}
+	defer j.Close()
+
+	cfg := testConfig(t)
+	cfg.Identifiers = []string{"cron", "sshd"}
+	jb, _ := newTestBeat(cfg)
+	jb.journal = j
+
+	var out bytes.Buffer
+	if err = jb.writeJournalStats(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{"Disk usage:", "Oldest entry:", "Newest entry:", "Filter:", "  SYSLOG_IDENTIFIER=cron OR SYSLOG_IDENTIFIER=sshd"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %q, want lines starting with %q", lines, want)
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("got line %q, want it to start with %q", line, want[i])
+		}
+	}
+}
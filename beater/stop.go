@@ -0,0 +1,77 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// stopBound is the end of a bounded backfill. The entry at the stop cursor and the count-th
+// entry are still published, the first entry after the stop time is not.
+type stopBound struct {
+	cursor    string
+	realtime  uint64
+	count     uint64
+	published uint64
+}
+
+func newStopBound(position, value string) (*stopBound, error) {
+	switch position {
+	case config.StopPositionCursor:
+		return &stopBound{cursor: value}, nil
+	case config.StopPositionRealtime:
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Stop Value %s: %v", value, err)
+		}
+		return &stopBound{realtime: uint64(t.UnixNano() / int64(time.Microsecond))}, nil
+	case config.StopPositionCount:
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || count == 0 {
+			return nil, fmt.Errorf("Invalid Stop Value %s: should be a positive number of events", value)
+		}
+		return &stopBound{count: count}, nil
+	}
+	return nil, nil
+}
+
+// exceeded reports whether the entry lies beyond the bound, so it is not published anymore
+func (b *stopBound) exceeded(entry *sdjournal.JournalEntry) bool {
+	return b.realtime > 0 && entry.RealtimeTimestamp > b.realtime
+}
+
+// reached reports whether the bound is reached with the entry, which is published
+func (b *stopBound) reached(entry *sdjournal.JournalEntry) bool {
+	if b.cursor != "" {
+		return entry.Cursor == b.cursor
+	}
+	if b.count > 0 {
+		b.published++
+		return b.published >= b.count
+	}
+	return false
+}
+
+// stopAtBound stops Journalbeat once the bounded backfill is done
+func (jb *Journalbeat) stopAtBound() {
+	logp.Info("Reached the stop position %s %s", jb.config.StopPosition, jb.config.StopValue)
+	jb.Stop()
+}
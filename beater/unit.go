@@ -65,83 +65,59 @@ var unitTypes = []string{
 	".scope",
 }
 
-// Add units to monitor
-func (jb *Journalbeat) addUnits() error {
+// unitTerms returns the filter terms for the units to monitor
+func (r *journalReader) unitTerms() ([]filterTerm, error) {
+	var terms []filterTerm
 	var patterns []string
 
 	// add specific units to monitor if any
-	for _, unit := range jb.config.Units {
+	for _, unit := range r.config.Units {
 		unit, err := unitNameMangle(unit, ".service")
 		if err != nil {
-			return fmt.Errorf("Filtering unit %s failed: %v", unit, err)
+			return nil, fmt.Errorf("Filtering unit %s failed: %v", unit, err)
 		}
 
 		if stringIsGlob(unit) {
 			patterns = append(patterns, unit)
 		} else {
-			if err = jb.addMatchesForUnit(unit); err != nil {
-				return fmt.Errorf("Filtering unit %s failed: %v", unit, err)
-			}
+			terms = append(terms, termsForUnit(unit)...)
 		}
 	}
 
 	// Now add glob pattern matches if/any
 	if len(patterns) > 0 {
 		var units []string
-		units = jb.getPossibleUnits(systemUnits, patterns)
+		units = r.getPossibleUnits(systemUnits, patterns)
 		for _, unit := range units {
-			if err := jb.addMatchesForUnit(unit); err != nil {
-				return fmt.Errorf("Filtering unit %s failed: %v", unit, err)
-			}
+			terms = append(terms, termsForUnit(unit)...)
 		}
 	}
 
-	return nil
+	return terms, nil
 }
 
 // See: https://github.com/systemd/systemd/blob/master/src/shared/logs-show.c#L1114
-func (jb *Journalbeat) addMatchesForUnit(unit string) error {
-	// Wrap AddMatch/AddDisjunction with function literal to avoid repeated checks against err.
-	var err error
-	AddMatch := func(s string) {
-		if err == nil {
-			err = jb.journal.AddMatch(s)
-		}
-	}
+func termsForUnit(unit string) []filterTerm {
+	terms := []filterTerm{
+		// Look for messages from the service itself
+		{"_SYSTEMD_UNIT=" + unit},
 
-	AddDisjunction := func() {
-		if err == nil {
-			err = jb.journal.AddDisjunction()
-		}
-	}
-
-	// Look for messages from the service itself
-	AddMatch("_SYSTEMD_UNIT=" + unit)
+		// Look for coredumps of the service
+		{"MESSAGE_ID=fc2e22bc6ee647b6b90729ab34a250b1", "_UID=0", "COREDUMP_UNIT=" + unit},
 
-	// Look for coredumps of the service
-	AddDisjunction()
-	AddMatch("MESSAGE_ID=fc2e22bc6ee647b6b90729ab34a250b1")
-	AddMatch("_UID=0")
-	AddMatch("COREDUMP_UNIT=" + unit)
+		// Look for messages from PID 1 about this service
+		{"_PID=1", "UNIT=" + unit},
 
-	// Look for messages from PID 1 about this service
-	AddDisjunction()
-	AddMatch("_PID=1")
-	AddMatch("UNIT=" + unit)
-
-	// Look for messages from authorized daemons about this service
-	AddDisjunction()
-	AddMatch("_UID=0")
-	AddMatch("OBJECT_SYSTEMD_UNIT=" + unit)
+		// Look for messages from authorized daemons about this service
+		{"_UID=0", "OBJECT_SYSTEMD_UNIT=" + unit},
+	}
 
 	// Show all messages belonging to a slice
-	if err == nil && strings.HasSuffix(unit, ".slice") {
-		AddDisjunction()
-		AddMatch("_SYSTEMD_SLICE=" + unit)
+	if strings.HasSuffix(unit, ".slice") {
+		terms = append(terms, filterTerm{"_SYSTEMD_SLICE=" + unit})
 	}
 
-	AddDisjunction()
-	return err
+	return terms
 }
 
 //  Convert a string to a unit name. /dev/blah is converted to dev-blah.device,
@@ -245,12 +221,12 @@ func unitNameIsValid(name string) bool {
 	return false
 }
 
-func (jb *Journalbeat) getPossibleUnits(fields, patterns []string) []string {
+func (r *journalReader) getPossibleUnits(fields, patterns []string) []string {
 	var found []string
 	var possibles []string
 
 	for _, field := range fields {
-		var vals, err = jb.journal.GetUniqueValues(field)
+		var vals, err = r.journal.GetUniqueValues(field)
 		if err != nil {
 			continue
 		}
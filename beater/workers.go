@@ -0,0 +1,82 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"sync"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// publishWorkers publishes the entries with worker count workers. The entries are still read
+// and converted one after the other, only publishing them runs concurrently, so the events may
+// be published and their cursors saved out of order.
+func (jb *Journalbeat) publishWorkers(entries <-chan *sdjournal.JournalEntry) error {
+	refs := make(chan *eventReference)
+	var workers sync.WaitGroup
+	workers.Add(jb.config.WorkerCount)
+	for i := 0; i < jb.config.WorkerCount; i++ {
+		go func() {
+			defer workers.Done()
+			for ref := range refs {
+				if jb.client.PublishEvent(ref.body, jb.publishOptions(jb.newEventSignal(ref))...) {
+					jb.published(ref)
+				}
+			}
+		}()
+	}
+
+	stop := jb.dispatch(entries, refs)
+	close(refs)
+	workers.Wait()
+
+	// the events up to the stop position are published before stopping
+	if stop {
+		jb.stopAtBound()
+		return nil
+	}
+	return jb.followEnded()
+}
+
+// dispatch hands the events of the entries over to the workers until following ends, Journalbeat
+// is stopped or the stop position is reached, which is reported by the result
+func (jb *Journalbeat) dispatch(entries <-chan *sdjournal.JournalEntry, refs chan<- *eventReference) bool {
+	for rawEvent := range entries {
+		ref := jb.eventFromEntry(rawEvent)
+		if ref == nil {
+			continue
+		}
+
+		if jb.stopBound != nil && jb.stopBound.exceeded(rawEvent) {
+			return true
+		}
+
+		if !jb.waitForPending() {
+			return false
+		}
+
+		jb.forward(ref)
+		select {
+		case <-jb.done:
+			return false
+		case refs <- ref:
+		}
+
+		if jb.stopBound != nil && jb.stopBound.reached(rawEvent) {
+			return true
+		}
+	}
+	return false
+}
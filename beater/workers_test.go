@@ -0,0 +1,110 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package beater
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elastic/beats/libbeat/beat"
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/common/op"
+	"github.com/elastic/beats/libbeat/publisher"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// nopClient completes the events right away without keeping them
+type nopClient struct{}
+
+func (nopClient) Close() error { return nil }
+
+func (c nopClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (nopClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	_, ctx := publisher.MakeContext(opts)
+	op.SigCompleted(ctx.Signal)
+	return true
+}
+
+// slowClient blocks each publish for latency before completing the event, like an output
+// waiting for the acknowledgement of a remote host
+type slowClient struct {
+	nopClient
+	latency time.Duration
+}
+
+func (c slowClient) PublishEvent(event common.MapStr, opts ...publisher.ClientOption) bool {
+	return c.PublishEvents([]common.MapStr{event}, opts...)
+}
+
+func (c slowClient) PublishEvents(events []common.MapStr, opts ...publisher.ClientOption) bool {
+	time.Sleep(c.latency)
+	return c.nopClient.PublishEvents(events, opts...)
+}
+
+func TestPublishWorkers(t *testing.T) {
+	var entries []*sdjournal.JournalEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, &sdjournal.JournalEntry{Fields: map[string]string{"MESSAGE": fmt.Sprint(i)}})
+	}
+	defer followScripted(entries...)()
+
+	cfg := testConfig(t)
+	cfg.WorkerCount = 4
+	cfg.MaxFollowRestarts = 0
+	jb, client := newTestBeat(cfg)
+	_ = jb.Run(&beat.Beat{Name: "journalbeat"})
+
+	// the workers publish out of order, but every event once
+	seen := map[string]int{}
+	for _, event := range client.published() {
+		seen[fmt.Sprint(event["MESSAGE"])]++
+	}
+	for i := 0; i < len(entries); i++ {
+		if n := seen[fmt.Sprint(i)]; n != 1 {
+			t.Errorf("event %d was published %d times", i, n)
+		}
+	}
+}
+
+// BenchmarkPublishWorkers reads, converts and publishes the entries to an output which blocks
+// each publish for a while, the time the workers save by waiting for the output at once
+func BenchmarkPublishWorkers(b *testing.B) {
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			entries := make([]*sdjournal.JournalEntry, b.N)
+			for i := range entries {
+				entries[i] = &sdjournal.JournalEntry{Fields: map[string]string{
+					"MESSAGE":       "hello",
+					"PRIORITY":      "6",
+					"_SYSTEMD_UNIT": "ssh.service",
+				}}
+			}
+			defer followScripted(entries...)()
+
+			cfg := testConfig(b)
+			cfg.WorkerCount = workers
+			cfg.MaxFollowRestarts = 0
+			jb, _ := newTestBeat(cfg)
+			jb.client = slowClient{latency: 200 * time.Microsecond}
+
+			b.ResetTimer()
+			_ = jb.Run(&beat.Beat{Name: "journalbeat"})
+		})
+	}
+}
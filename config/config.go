@@ -21,98 +21,770 @@ import (
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/outputs"
+	"github.com/elastic/beats/libbeat/paths"
 )
 
 // Config provides the config settings for the journald reader
 type Config struct {
-	SeekPosition         string             `config:"seek_position"`
-	ConvertToNumbers     bool               `config:"convert_to_numbers"`
-	CleanFieldNames      bool               `config:"clean_field_names"`
-	WriteCursorState     bool               `config:"write_cursor_state"`
-	CursorStateFile      string             `config:"cursor_state_file"`
-	CursorFlushPeriod    time.Duration      `config:"cursor_flush_period" validate:"min=0"`
-	PendingQueue         pendingQueueConfig `config:"pending_queue"`
-	CursorSeekFallback   string             `config:"cursor_seek_fallback"`
-	MoveMetadataLocation string             `config:"move_metadata_to_field"`
-	DefaultType          string             `config:"default_type"`
-	Units                []string           `config:"units"`
-	Kernel               bool               `config:"kernel"`
-	Identifiers          []string           `config:"identifiers"`
-	JournalPaths         []string           `config:"journal_paths"`
-	MatchPatterns        []string           `config:"match_patterns"`
-	ParseSyslogFacility  bool               `config:"parse_syslog_facility"`
-	ParsePriority        bool               `config:"parse_priority"`
+	SeekPosition           string             `config:"seek_position"`
+	SeekTime               time.Duration      `config:"seek_time"`
+	TailEntries            uint64             `config:"tail_entries"`
+	HeadSkip               uint64             `config:"head_skip"`
+	StopPosition           string             `config:"stop_position"`
+	StopValue              string             `config:"stop_value"`
+	ConvertToNumbers       bool               `config:"convert_to_numbers"`
+	NumberFields           []string           `config:"number_fields"`
+	NumberExclude          []string           `config:"number_exclude"`
+	ConvertToBooleans      bool               `config:"convert_to_booleans"`
+	CleanFieldNames        bool               `config:"clean_field_names"`
+	KeepOriginalFields     bool               `config:"keep_original_fields"`
+	OriginalFieldsKey      string             `config:"original_fields_key"`
+	SanitizeKeys           map[string]string  `config:"sanitize_keys"`
+	WriteCursorState       bool               `config:"write_cursor_state"`
+	CursorStateFile        string             `config:"cursor_state_file"`
+	CursorStore            string             `config:"cursor_store"`
+	CursorStoreURL         string             `config:"cursor_store_url"`
+	CursorStoreUsername    string             `config:"cursor_store_username"`
+	CursorStorePassword    string             `config:"cursor_store_password"`
+	CursorStoreSSL         *outputs.TLSConfig `config:"cursor_store_ssl"`
+	CursorBackupFile       string             `config:"cursor_backup_file"`
+	CursorStateBackups     int                `config:"cursor_state_backups" validate:"min=0"`
+	CursorFlushPeriod      time.Duration      `config:"cursor_flush_period" validate:"min=0"`
+	CursorDebounce         time.Duration      `config:"cursor_debounce" validate:"min=0"`
+	PendingQueue           pendingQueueConfig `config:"pending_queue"`
+	CursorSeekFallback     string             `config:"cursor_seek_fallback"`
+	CursorMaxAge           time.Duration      `config:"cursor_max_age" validate:"min=0"`
+	StrictCursor           bool               `config:"strict_cursor"`
+	MoveMetadataLocation   string             `config:"move_metadata_to_field"`
+	MetadataFlatten        bool               `config:"metadata_flatten"`
+	IncludeCursor          bool               `config:"include_cursor"`
+	Fields                 common.MapStr      `config:"fields"`
+	FieldsUnderRoot        bool               `config:"fields_under_root"`
+	AddHostMetadata        bool               `config:"add_host_metadata"`
+	CursorField            string             `config:"cursor_field"`
+	FieldMapping           map[string]string  `config:"field_mapping"`
+	IncludeFields          []string           `config:"include_fields"`
+	DropFields             []string           `config:"drop_fields"`
+	DefaultType            string             `config:"default_type"`
+	InputType              string             `config:"input_type"`
+	LogstashCompat         bool               `config:"logstash_compat"`
+	MessageField           string             `config:"message_field"`
+	RequireMessage         bool               `config:"require_message"`
+	MessageFormat          string             `config:"message_format"`
+	DecodeJSONMessage      bool               `config:"decode_json_message"`
+	JSONMessage            JSONMessageConfig  `config:"json_message"`
+	CEEFields              []string           `config:"cee_fields"`
+	OutputFormat           string             `config:"output_format"`
+	Units                  []string           `config:"units"`
+	UnitPatterns           []string           `config:"unit_patterns"`
+	Kernel                 bool               `config:"kernel"`
+	Identifiers            []string           `config:"identifiers"`
+	Transports             []string           `config:"transports"`
+	ExcludeSelf            bool               `config:"exclude_self"`
+	JournalPaths           []string           `config:"journal_paths"`
+	JournalNamespace       string             `config:"journal_namespace"`
+	NamespaceMode          string             `config:"namespace_mode"`
+	JournalScope           string             `config:"journal_scope"`
+	VerifyFSS              bool               `config:"verify_fss"`
+	FSSKeyFile             string             `config:"fss_key_file"`
+	FSSOnFailure           string             `config:"fss_on_failure"`
+	FSSKeyInArgv           bool               `config:"fss_key_in_argv"`
+	MatchPatterns          []string           `config:"match_patterns"`
+	Matches                []string           `config:"matches"`
+	MatchesMode            string             `config:"matches_mode"`
+	FilterMode             string             `config:"filter_mode"`
+	Filters                [][]string         `config:"filters"`
+	ParseSyslogFacility    bool               `config:"parse_syslog_facility"`
+	ParsePriority          bool               `config:"parse_priority"`
+	PriorityMap            PriorityNames      `config:"priority_map"`
+	PriorityField          string             `config:"priority_field"`
+	PriorityKeepRaw        bool               `config:"priority_keep_raw"`
+	DecodeSeqnum           bool               `config:"decode_seqnum"`
+	EmitProcessingErrors   bool               `config:"emit_processing_errors"`
+	DecodeCmdline          bool               `config:"decode_cmdline"`
+	ParseAudit             bool               `config:"parse_audit"`
+	EnableCatalog          bool               `config:"enable_catalog"`
+	CatalogRaw             bool               `config:"catalog_raw"`
+	CatalogCacheSize       int                `config:"catalog_cache_size" validate:"min=0"`
+	CatalogSanitize        bool               `config:"catalog_sanitize"`
+	ContextBefore          uint64             `config:"context_before"`
+	ContextMaxPriority     int                `config:"context_max_priority" validate:"min=0"`
+	MaxFieldsPerEvent      int                `config:"max_fields_per_event" validate:"min=0"`
+	MaxFieldBytes          int                `config:"max_field_bytes" validate:"min=0"`
+	MaxMessageChars        int                `config:"max_message_chars" validate:"min=0"`
+	BinaryFieldHandling    string             `config:"binary_field_handling"`
+	FileInputs             []FileInput        `config:"file_inputs"`
+	JournalSources         []JournalSource    `config:"journal_sources"`
+	FileOutput             FileOutput         `config:"file_output"`
+	ForwardSocket          string             `config:"forward_socket"`
+	TimestampField         string             `config:"timestamp_field"`
+	RealtimeTimestampField string             `config:"realtime_timestamp_field"`
+	UseSourceTimestamp     bool               `config:"use_source_timestamp"`
+	TimestampUnit          string             `config:"timestamp_unit"`
+	ExcludePriorities      []int              `config:"exclude_priorities"`
+	MaxPriority            *int               `config:"max_priority"`
+	CurrentBootOnly        bool               `config:"current_boot_only"`
+	BootOffset             int                `config:"boot_offset" validate:"max=0"`
+	EmbedStatsEvery        int                `config:"embed_stats_every" validate:"min=0"`
+	UsageReportPeriod      time.Duration      `config:"usage_report_period" validate:"min=0"`
+	EmitUsageEvents        bool               `config:"emit_usage_events"`
+	HeartbeatPeriod        time.Duration      `config:"heartbeat_period" validate:"min=0"`
+	BatchSize              int                `config:"batch_size" validate:"min=1"`
+	WorkerCount            int                `config:"worker_count" validate:"min=1"`
+	MaxPending             int                `config:"max_pending" validate:"min=0"`
+	PublishMode            string             `config:"publish_mode"`
+	RateLimit              RateLimitConfig    `config:"rate_limit"`
+	DedupeOnStart          bool               `config:"dedupe_on_start"`
+	MaxFollowRestarts      int                `config:"max_follow_restarts" validate:"min=0"`
+	ReconnectBackoffMax    time.Duration      `config:"reconnect_backoff_max" validate:"min=0"`
+	LevelFromMessage       bool               `config:"level_from_message"`
+	LevelTokens            map[string]string  `config:"level_tokens"`
+	DurableWrites          bool               `config:"durable_writes"`
+	CursorFsync            bool               `config:"cursor_fsync"`
+	DryRun                 bool               `config:"dry_run"`
+	EmitShutdownSummary    bool               `config:"emit_shutdown_summary"`
+	EmitBootMarkers        bool               `config:"emit_boot_markers"`
+	ShutdownSummaryTimeout time.Duration      `config:"shutdown_summary_timeout" validate:"min=0"`
+	ShutdownDrainTimeout   time.Duration      `config:"shutdown_drain_timeout" validate:"min=0"`
+}
+
+// FileInput provides the config settings for a classic log file tailed next to the journal
+type FileInput struct {
+	Path       string `config:"path" validate:"required"`
+	Type       string `config:"type"`
+	OffsetFile string `config:"offset_file"`
+}
+
+// JournalSource provides the config settings for an additional journal read by a reader of its own
+type JournalSource struct {
+	Name    string   `config:"name" validate:"required"`
+	Paths   []string `config:"paths"`
+	Units   []string `config:"units"`
+	Matches []string `config:"matches"`
+}
+
+// FileOutput provides the config settings for writing the events to a local file instead of
+// publishing them through the libbeat outputs
+type FileOutput struct {
+	Path     string `config:"path"`
+	MaxSize  int64  `config:"max_size" validate:"min=0"`
+	MaxFiles int    `config:"max_files" validate:"min=0"`
+}
+
+// JSONMessageConfig provides the config settings for decoding the JSON objects logged as the message
+type JSONMessageConfig struct {
+	Target      string `config:"target"`
+	KeepMessage bool   `config:"keep_message"`
+	AddErrorKey bool   `config:"add_error_key"`
+	MaxBytes    int    `config:"max_bytes" validate:"min=0"`
+	MaxDepth    int    `config:"max_depth" validate:"min=0"`
+}
+
+// RateLimitConfig provides the config settings for limiting the events per unit
+type RateLimitConfig struct {
+	Field   string      `config:"field"`
+	Default RateLimit   `config:"default"`
+	Limits  []RateLimit `config:"limits"`
+}
+
+// RateLimit is the max number of events per second and the number of events allowed at once
+// for the entries with the given value of the rate limited field
+type RateLimit struct {
+	Value string  `config:"value"`
+	Rate  float64 `config:"rate" validate:"min=0"`
+	Burst int     `config:"burst" validate:"min=0"`
 }
 
 type pendingQueueConfig struct {
 	File               string        `config:"file"`
 	FlushPeriod        time.Duration `config:"flush_period" validate:"min=0"`
 	CompletedQueueSize uint16        `config:"completed_queue_size"`
+	Compression        string        `config:"compression"`
+	MaxRetries         int           `config:"max_retries" validate:"min=0"`
+	DeadLetterFile     string        `config:"dead_letter_file"`
+	MaxUnflushed       int           `config:"max_unflushed" validate:"min=0"`
+	DeadLetterFailed   bool          `config:"dead_letter_failed"`
+}
+
+// PriorityNames maps the priorities to their names
+type PriorityNames map[string]string
+
+// Unpack reads priority_map. ucfg takes the numeric keys of a map for list indexes, so the map
+// is also read from a list, where the unset priorities are nil.
+func (names *PriorityNames) Unpack(v interface{}) error {
+	m := PriorityNames{}
+	set := func(priority string, name interface{}) error {
+		switch name := name.(type) {
+		case nil:
+		case string:
+			m[priority] = name
+		default:
+			return fmt.Errorf("Invalid Priority Map: the name of priority %s is not a string: %v", priority, name)
+		}
+		return nil
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for priority, name := range v {
+			if err := set(priority, name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for priority, name := range v {
+			if err := set(strconv.Itoa(priority), name); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Invalid Priority Map: %v. Should map the priorities to their names", v)
+	}
+	*names = m
+	return nil
 }
 
 // Named constants for the journal cursor placement positions
 const (
-	SeekPositionCursor         = "cursor"
-	SeekPositionHead           = "head"
-	SeekPositionTail           = "tail"
-	SeekPositionDefault        = "none"
-	CompletedQueueSize  uint16 = 2 << 12
+	SeekPositionCursor             = "cursor"
+	SeekPositionHead               = "head"
+	SeekPositionTail               = "tail"
+	SeekPositionSince              = "since"
+	SeekPositionRealtimeNow        = "realtime_now"
+	SeekPositionDefault            = "none"
+	CompletedQueueSize      uint16 = 2 << 12
+)
+
+// Named constants for the positions a bounded backfill stops at
+const (
+	StopPositionCursor   = "cursor"
+	StopPositionRealtime = "realtime"
+	StopPositionCount    = "count"
+)
+
+// Named constants for the stores of the cursor
+const (
+	CursorStoreFile = "file"
+	CursorStoreHTTP = "http"
+)
+
+// Named constants for the compression of the pending queue file
+const (
+	PendingQueueCompressionNone = "none"
+	PendingQueueCompressionGzip = "gzip"
+)
+
+// Named constants for the units of epoch timestamps
+const (
+	TimestampUnitSeconds      = "s"
+	TimestampUnitMilliseconds = "ms"
+	TimestampUnitMicroseconds = "us"
+	TimestampUnitNanoseconds  = "ns"
+)
+
+// Named constants for the formats of the message
+const (
+	MessageFormatRaw = "raw"
+	MessageFormatCEE = "cee"
+)
+
+// Named constants for the output formats of the events
+const (
+	OutputFormatECS  = "ecs"
+	OutputFormatGELF = "gelf"
+)
+
+// Named constants for the handling of field values which are not valid UTF-8
+const (
+	BinaryFieldHandlingNone   = "none"
+	BinaryFieldHandlingBase64 = "base64"
+	BinaryFieldHandlingHex    = "hex"
+	BinaryFieldHandlingDrop   = "drop"
+)
+
+// Named constants for the transports of the journal entries, see systemd.journal-fields(7)
+const (
+	TransportAudit   = "audit"
+	TransportDriver  = "driver"
+	TransportSyslog  = "syslog"
+	TransportJournal = "journal"
+	TransportStdout  = "stdout"
+	TransportKernel  = "kernel"
+)
+
+// Named constants for the scopes of the journal files to open
+const (
+	JournalScopeAll     = "all"
+	JournalScopeRuntime = "runtime"
+	JournalScopeSystem  = "system"
+)
+
+// Named constants for what to do when verifying the journal fails
+const (
+	FSSOnFailureFail = "fail"
+	FSSOnFailureWarn = "warn"
+)
+
+// Named constants for the publish modes
+const (
+	PublishModeGuaranteed = "guaranteed"
+	PublishModeSync       = "sync"
+	PublishModeAsync      = "async"
+	PublishModeDropIfFull = "drop_if_full"
+)
+
+// Named constants for the modes combining the units, identifiers and match patterns
+const (
+	FilterModeOr  = "or"
+	FilterModeAnd = "and"
+)
+
+// Named constants for the modes combining the matches
+const (
+	MatchesModeAnd = "and"
+	MatchesModeOr  = "or"
+)
+
+// Named constants for the journal namespace modes
+const (
+	NamespaceModeSingle         = "single"
+	NamespaceModeAll            = "all"
+	NamespaceModeIncludeDefault = "include-default"
 )
 
 var (
 	seekPositions = map[string]struct{}{
-		SeekPositionCursor: {},
-		SeekPositionHead:   {},
-		SeekPositionTail:   {},
+		SeekPositionCursor:      {},
+		SeekPositionHead:        {},
+		SeekPositionTail:        {},
+		SeekPositionSince:       {},
+		SeekPositionRealtimeNow: {},
 	}
 
 	seekFallbackPositions = map[string]struct{}{
-		SeekPositionDefault: {},
-		SeekPositionHead:    {},
-		SeekPositionTail:    {},
+		SeekPositionDefault:     {},
+		SeekPositionHead:        {},
+		SeekPositionTail:        {},
+		SeekPositionSince:       {},
+		SeekPositionRealtimeNow: {},
+	}
+
+	timestampUnits = map[string]struct{}{
+		"":                        {},
+		TimestampUnitSeconds:      {},
+		TimestampUnitMilliseconds: {},
+		TimestampUnitMicroseconds: {},
+		TimestampUnitNanoseconds:  {},
+	}
+
+	binaryFieldHandlings = map[string]struct{}{
+		BinaryFieldHandlingNone:   {},
+		BinaryFieldHandlingBase64: {},
+		BinaryFieldHandlingHex:    {},
+		BinaryFieldHandlingDrop:   {},
+	}
+
+	publishModes = map[string]struct{}{
+		PublishModeGuaranteed: {},
+		PublishModeSync:       {},
+		PublishModeAsync:      {},
+		PublishModeDropIfFull: {},
+	}
+
+	journalScopes = map[string]struct{}{
+		JournalScopeAll:     {},
+		JournalScopeRuntime: {},
+		JournalScopeSystem:  {},
+	}
+
+	filterModes = map[string]struct{}{
+		FilterModeOr:  {},
+		FilterModeAnd: {},
+	}
+
+	matchesModes = map[string]struct{}{
+		MatchesModeAnd: {},
+		MatchesModeOr:  {},
+	}
+
+	transports = map[string]struct{}{
+		TransportAudit:   {},
+		TransportDriver:  {},
+		TransportSyslog:  {},
+		TransportJournal: {},
+		TransportStdout:  {},
+		TransportKernel:  {},
+	}
+
+	namespaceModes = map[string]struct{}{
+		NamespaceModeSingle:         {},
+		NamespaceModeAll:            {},
+		NamespaceModeIncludeDefault: {},
+	}
+
+	// The default maps are not part of DefaultConfig: the maps of the configuration are merged into
+	// the ones unpacked into, which would change the defaults and keep their keys. They are copied
+	// into the configuration by Validate if the map is not configured.
+	defaultSanitizeKeys = map[string]string{".": "_"}
+	defaultPriorityMap  = PriorityNames{
+		"0": "emergency",
+		"1": "alert",
+		"2": "critical",
+		"3": "error",
+		"4": "warning",
+		"5": "notice",
+		"6": "info",
+		"7": "debug",
+	}
+	defaultLevelTokens = map[string]string{
+		"TRACE":    "trace",
+		"DEBUG":    "debug",
+		"INFO":     "info",
+		"NOTICE":   "notice",
+		"WARN":     "warning",
+		"WARNING":  "warning",
+		"ERR":      "error",
+		"ERROR":    "error",
+		"CRIT":     "critical",
+		"CRITICAL": "critical",
+		"FATAL":    "critical",
 	}
 
 	// DefaultConfig is an instance of Config with default settings
 	DefaultConfig = Config{
 		SeekPosition:       SeekPositionTail,
 		CursorStateFile:    ".journalbeat-cursor-state",
+		CursorStore:        CursorStoreFile,
 		CursorFlushPeriod:  5 * time.Second,
+		CursorFsync:        true,
 		CursorSeekFallback: SeekPositionTail,
 		PendingQueue: pendingQueueConfig{
 			File:               ".journalbeat-pending-queue",
 			FlushPeriod:        1 * time.Second,
 			CompletedQueueSize: CompletedQueueSize,
+			Compression:        PendingQueueCompressionNone,
+			DeadLetterFile:     ".journalbeat-dead-letter",
+		},
+		DefaultType:        "journal",
+		InputType:          "journal",
+		Kernel:             true,
+		EnableCatalog:      true,
+		CatalogSanitize:    true,
+		ContextMaxPriority: 3,
+		ExcludeSelf:        true,
+		NamespaceMode:      NamespaceModeSingle,
+		JournalScope:       JournalScopeAll,
+		FSSOnFailure:       FSSOnFailureFail,
+		MatchesMode:        MatchesModeAnd,
+		FilterMode:         FilterModeOr,
+		MaxFollowRestarts:  3,
+		BatchSize:          1,
+		WorkerCount:        1,
+		PublishMode:        PublishModeGuaranteed,
+		UseSourceTimestamp: true,
+		MessageField:       "MESSAGE",
+		ConvertToBooleans:  true,
+		MessageFormat:      MessageFormatRaw,
+		OutputFormat:       OutputFormatECS,
+		JSONMessage: JSONMessageConfig{
+			KeepMessage: true,
+			MaxBytes:    64 * 1024,
+			MaxDepth:    10,
+		},
+		CursorField:            "journal_cursor",
+		OriginalFieldsKey:      "original",
+		RealtimeTimestampField: "@realtime_timestamp",
+		BinaryFieldHandling:    BinaryFieldHandlingNone,
+		ShutdownSummaryTimeout: 5 * time.Second,
+		RateLimit: RateLimitConfig{
+			Field: "_SYSTEMD_UNIT",
+		},
+		FileOutput: FileOutput{
+			MaxSize:  100 * 1024 * 1024,
+			MaxFiles: 7,
 		},
-		DefaultType: "journal",
-		Kernel:      true,
+		PriorityField:   "log.level",
+		PriorityKeepRaw: true,
 	}
 )
 
 // Validate turns Config into implementation of Validator and will be executed when Unpack is called
 func (config *Config) Validate() error {
-	// validate MoveMetadataLocation against the regexp. We don't want extra dots to appear
-	validID := regexp.MustCompile(`\.{2,}|\.$`)
+	config.SanitizeKeys = withDefault(config.SanitizeKeys, defaultSanitizeKeys)
+	config.PriorityMap = withDefault(config.PriorityMap, defaultPriorityMap)
+	config.LevelTokens = withDefault(config.LevelTokens, defaultLevelTokens)
+
+	// validate MoveMetadataLocation against the regexp. We don't want extra dots or blank names to appear,
+	// they would create fields with empty names
+	validID := regexp.MustCompile(`^\.|\.{2,}|\.$|(^|\.)\s+(\.|$)`)
 	if config.MoveMetadataLocation != "" && validID.MatchString(config.MoveMetadataLocation) {
 		return fmt.Errorf("Wrong location for the Journal Metadata: %s", config.MoveMetadataLocation)
 	}
 
 	if _, ok := seekPositions[config.SeekPosition]; !ok {
-		return fmt.Errorf("Invalid Seek Position: %v. Should be %s, %s or %s", config.SeekPosition, SeekPositionCursor, SeekPositionHead, SeekPositionTail)
+		return fmt.Errorf("Invalid Seek Position: %v. Should be %s, %s, %s, %s or %s", config.SeekPosition, SeekPositionCursor, SeekPositionHead, SeekPositionTail, SeekPositionSince, SeekPositionRealtimeNow)
 	}
 
 	if _, ok := seekFallbackPositions[config.CursorSeekFallback]; !ok {
-		return fmt.Errorf("Invalid Cursor Seek Fallback Position: %v. Should be %s, %s or %s", config.SeekPosition, SeekPositionTail, SeekPositionHead, SeekPositionDefault)
+		return fmt.Errorf("Invalid Cursor Seek Fallback Position: %v. Should be %s, %s, %s, %s or %s", config.SeekPosition, SeekPositionTail, SeekPositionHead, SeekPositionSince, SeekPositionRealtimeNow, SeekPositionDefault)
 	}
-	fp, err := filepath.Abs(config.PendingQueue.File)
-	if err != nil {
-		return fmt.Errorf("Invalid path %s: %v", config.PendingQueue.File, err)
+
+	if config.SeekPosition == SeekPositionSince || config.CursorSeekFallback == SeekPositionSince {
+		// seek_time is relative to now, a positive one would seek into the future
+		if config.SeekTime >= 0 {
+			return fmt.Errorf("Invalid Seek Time: %v. Seek position %s requires a negative seek time, e.g. -1h", config.SeekTime, SeekPositionSince)
+		}
+	}
+
+	if config.TailEntries > 0 && config.SeekPosition != SeekPositionTail && config.CursorSeekFallback != SeekPositionTail {
+		return fmt.Errorf("Tail entries require the seek position or the cursor seek fallback %s", SeekPositionTail)
+	}
+
+	if config.HeadSkip > 0 && config.SeekPosition != SeekPositionHead && config.CursorSeekFallback != SeekPositionHead {
+		return fmt.Errorf("Head skip requires the seek position or the cursor seek fallback %s", SeekPositionHead)
+	}
+
+	switch config.StopPosition {
+	case "":
+	case StopPositionCursor, StopPositionRealtime, StopPositionCount:
+		if config.StopValue == "" {
+			return fmt.Errorf("Stop position %s requires a stop value", config.StopPosition)
+		}
+	default:
+		return fmt.Errorf("Invalid Stop Position: %v. Should be %s, %s or %s", config.StopPosition, StopPositionCursor, StopPositionRealtime, StopPositionCount)
+	}
+
+	switch config.CursorStore {
+	case CursorStoreFile:
+	case CursorStoreHTTP:
+		if config.CursorStoreURL == "" {
+			return fmt.Errorf("Cursor store %s requires a cursor store url", CursorStoreHTTP)
+		}
+		if config.CursorStateBackups > 0 {
+			return fmt.Errorf("Cursor state backups can only be combined with the cursor store %s", CursorStoreFile)
+		}
+		if config.CursorBackupFile != "" {
+			return fmt.Errorf("Cursor backup file can only be combined with the cursor store %s", CursorStoreFile)
+		}
+	default:
+		return fmt.Errorf("Invalid Cursor Store: %v. Should be %s or %s", config.CursorStore, CursorStoreFile, CursorStoreHTTP)
+	}
+
+	if config.PendingQueue.Compression != PendingQueueCompressionNone && config.PendingQueue.Compression != PendingQueueCompressionGzip {
+		return fmt.Errorf("Invalid Pending Queue Compression: %v. Should be %s or %s", config.PendingQueue.Compression, PendingQueueCompressionNone, PendingQueueCompressionGzip)
+	}
+
+	if _, ok := filterModes[config.FilterMode]; !ok {
+		return fmt.Errorf("Invalid Filter Mode: %v. Should be %s or %s", config.FilterMode, FilterModeOr, FilterModeAnd)
+	}
+
+	if _, ok := matchesModes[config.MatchesMode]; !ok {
+		return fmt.Errorf("Invalid Matches Mode: %v. Should be %s or %s", config.MatchesMode, MatchesModeAnd, MatchesModeOr)
+	}
+
+	for _, transport := range config.Transports {
+		if _, ok := transports[transport]; !ok {
+			return fmt.Errorf("Invalid Transport: %v. Should be %s, %s, %s, %s, %s or %s", transport, TransportAudit, TransportDriver, TransportSyslog, TransportJournal, TransportStdout, TransportKernel)
+		}
+	}
+
+	if _, ok := namespaceModes[config.NamespaceMode]; !ok {
+		return fmt.Errorf("Invalid Namespace Mode: %v. Should be %s, %s or %s", config.NamespaceMode, NamespaceModeSingle, NamespaceModeAll, NamespaceModeIncludeDefault)
+	}
+
+	if config.NamespaceMode == NamespaceModeIncludeDefault && config.JournalNamespace == "" {
+		return fmt.Errorf("Namespace Mode %s requires a journal namespace", NamespaceModeIncludeDefault)
+	}
+
+	if (config.JournalNamespace != "" || config.NamespaceMode == NamespaceModeAll) && len(config.JournalPaths) > 0 {
+		return fmt.Errorf("Journal namespaces can not be combined with journal paths")
+	}
+
+	if config.FSSOnFailure != FSSOnFailureFail && config.FSSOnFailure != FSSOnFailureWarn {
+		return fmt.Errorf("Invalid FSS On Failure: %v. Should be %s or %s", config.FSSOnFailure, FSSOnFailureFail, FSSOnFailureWarn)
+	}
+
+	// journalctl only takes the verification key on its command line, visible to all local users
+	if config.VerifyFSS && config.FSSKeyFile != "" && !config.FSSKeyInArgv {
+		return fmt.Errorf("FSS key file requires fss_key_in_argv: the verification key is passed on the command line of journalctl, where all local users can read it")
+	}
+
+	if config.WorkerCount > 1 && config.BatchSize > 1 {
+		return fmt.Errorf("Worker count can not be combined with batch size")
+	}
+
+	if _, ok := publishModes[config.PublishMode]; !ok {
+		return fmt.Errorf("Invalid Publish Mode: %v. Should be %s, %s, %s or %s", config.PublishMode, PublishModeGuaranteed, PublishModeSync, PublishModeAsync, PublishModeDropIfFull)
+	}
+
+	// drop_if_full drops the entries once max_pending events are waiting, it does nothing without
+	if config.PublishMode == PublishModeDropIfFull && config.MaxPending == 0 {
+		return fmt.Errorf("Publish mode %s requires max_pending", PublishModeDropIfFull)
+	}
+
+	if _, ok := journalScopes[config.JournalScope]; !ok {
+		return fmt.Errorf("Invalid Journal Scope: %v. Should be %s, %s or %s", config.JournalScope, JournalScopeAll, JournalScopeRuntime, JournalScopeSystem)
+	}
+
+	if config.JournalScope != JournalScopeAll && len(config.JournalPaths) > 0 {
+		return fmt.Errorf("Journal scope %s can not be combined with journal paths", config.JournalScope)
+	}
+
+	if _, ok := timestampUnits[config.TimestampUnit]; !ok {
+		return fmt.Errorf("Invalid Timestamp Unit: %v. Should be %s, %s, %s or %s", config.TimestampUnit, TimestampUnitSeconds, TimestampUnitMilliseconds, TimestampUnitMicroseconds, TimestampUnitNanoseconds)
+	}
+
+	if config.MessageFormat != MessageFormatRaw && config.MessageFormat != MessageFormatCEE {
+		return fmt.Errorf("Invalid Message Format: %v. Should be %s or %s", config.MessageFormat, MessageFormatRaw, MessageFormatCEE)
+	}
+
+	if config.OutputFormat != OutputFormatECS && config.OutputFormat != OutputFormatGELF {
+		return fmt.Errorf("Invalid Output Format: %v. Should be %s or %s", config.OutputFormat, OutputFormatECS, OutputFormatGELF)
+	}
+
+	if _, ok := binaryFieldHandlings[config.BinaryFieldHandling]; !ok {
+		return fmt.Errorf("Invalid Binary Field Handling: %v. Should be %s, %s, %s or %s", config.BinaryFieldHandling, BinaryFieldHandlingNone, BinaryFieldHandlingBase64, BinaryFieldHandlingHex, BinaryFieldHandlingDrop)
+	}
+
+	if config.MaxPriority != nil && (*config.MaxPriority < 0 || *config.MaxPriority > 7) {
+		return fmt.Errorf("Invalid Max Priority: %d. Should be between 0 and 7", *config.MaxPriority)
+	}
+
+	for old := range config.SanitizeKeys {
+		if old == "" {
+			return fmt.Errorf("Invalid Sanitize Keys: the characters to replace must not be empty")
+		}
+	}
+
+	for field, mapped := range config.FieldMapping {
+		if mapped == "" || validID.MatchString(mapped) {
+			return fmt.Errorf("Invalid Field Mapping for %s: %s", field, mapped)
+		}
+	}
+
+	if config.MessageField == "" {
+		return fmt.Errorf("Invalid Message Field: the message field must not be empty")
+	}
+
+	if config.IncludeCursor && (config.CursorField == "" || validID.MatchString(config.CursorField)) {
+		return fmt.Errorf("Invalid Cursor Field: %s", config.CursorField)
+	}
+
+	if (config.ParsePriority || config.LevelFromMessage) && (config.PriorityField == "" || validID.MatchString(config.PriorityField)) {
+		return fmt.Errorf("Invalid Priority Field: %s", config.PriorityField)
+	}
+
+	if config.KeepOriginalFields && (config.OriginalFieldsKey == "" || validID.MatchString(config.OriginalFieldsKey)) {
+		return fmt.Errorf("Invalid Original Fields Key: %s", config.OriginalFieldsKey)
+	}
+
+	sourceNames := map[string]struct{}{}
+	for _, source := range config.JournalSources {
+		if _, ok := sourceNames[source.Name]; ok {
+			return fmt.Errorf("Duplicate Journal Source name: %s", source.Name)
+		}
+		sourceNames[source.Name] = struct{}{}
 	}
-	config.PendingQueue.File = fp
-	fp, err = filepath.Abs(config.CursorStateFile)
+
+	for _, priority := range config.ExcludePriorities {
+		if priority < 0 || priority > 7 {
+			return fmt.Errorf("Invalid Priority to exclude: %d. Should be between 0 and 7", priority)
+		}
+	}
+
+	// durable writes cover the cursor as well
+	if config.DurableWrites {
+		config.CursorFsync = true
+	}
+
+	// an empty pending queue file turns the pending queue off
+	var err error
+	if config.PendingQueue.File != "" {
+		if config.PendingQueue.File, err = filepath.Abs(config.PendingQueue.File); err != nil {
+			return fmt.Errorf("Invalid path %s: %v", config.PendingQueue.File, err)
+		}
+	}
+	if config.PendingQueue.MaxRetries > 0 || config.PendingQueue.DeadLetterFailed {
+		if config.PendingQueue.DeadLetterFile == "" {
+			return fmt.Errorf("Pending queue max retries and dead letter failed require a dead letter file")
+		}
+		if config.PendingQueue.DeadLetterFile, err = filepath.Abs(config.PendingQueue.DeadLetterFile); err != nil {
+			return fmt.Errorf("Invalid path %s: %v", config.PendingQueue.DeadLetterFile, err)
+		}
+	}
+	// relative cursor state files are resolved against path.data like the registry of other beats
+	fp, err := filepath.Abs(paths.Resolve(paths.Data, config.CursorStateFile))
 	if err != nil {
 		return fmt.Errorf("Invalid path %s: %v", config.CursorStateFile, err)
 	}
 	config.CursorStateFile = fp
+	if config.CursorBackupFile != "" {
+		fp, err = filepath.Abs(paths.Resolve(paths.Data, config.CursorBackupFile))
+		if err != nil {
+			return fmt.Errorf("Invalid path %s: %v", config.CursorBackupFile, err)
+		}
+		config.CursorBackupFile = fp
+	}
+	if config.FileOutput.Path != "" {
+		if config.FileOutput.Path, err = filepath.Abs(config.FileOutput.Path); err != nil {
+			return fmt.Errorf("Invalid path %s: %v", config.FileOutput.Path, err)
+		}
+	}
+	for i := range config.FileInputs {
+		input := &config.FileInputs[i]
+		if input.OffsetFile == "" {
+			// keep the offset next to the cursor state, named after the tailed file
+			input.OffsetFile = filepath.Join(filepath.Dir(config.CursorStateFile),
+				".journalbeat-offset"+strings.Replace(filepath.Clean(input.Path), string(filepath.Separator), "-", -1))
+		}
+		if input.OffsetFile, err = filepath.Abs(input.OffsetFile); err != nil {
+			return fmt.Errorf("Invalid path %s: %v", input.OffsetFile, err)
+		}
+	}
 	return nil
 }
+
+// ApplyLogstashCompat fills the settings of the logstash_compat preset which are not set in cfg,
+// the configuration the config was unpacked from, so each of them can still be overridden:
+// the fields are cleaned and kept on the top level with dotted names under journal, and the
+// syslog identifier, the PID and the host name are mapped to the fields of the syslog pattern
+// of Logstash. The input_type is log, as for the events of Filebeat.
+func (config *Config) ApplyLogstashCompat(cfg *common.Config) {
+	if !cfg.HasField("clean_field_names") {
+		config.CleanFieldNames = true
+	}
+	if !cfg.HasField("move_metadata_to_field") {
+		config.MoveMetadataLocation = "journal"
+	}
+	if !cfg.HasField("metadata_flatten") {
+		config.MetadataFlatten = true
+	}
+	if !cfg.HasField("field_mapping") {
+		config.FieldMapping = map[string]string{
+			"SYSLOG_IDENTIFIER": "program",
+			"_PID":              "pid",
+			"_HOSTNAME":         "logsource",
+		}
+	}
+	if !cfg.HasField("input_type") {
+		config.InputType = "log"
+	}
+}
+
+// withDefault returns the map, or a copy of the default map if the map is not set
+func withDefault(m, def map[string]string) map[string]string {
+	if m != nil {
+		return m
+	}
+	m = make(map[string]string, len(def))
+	for k, v := range def {
+		m[k] = v
+	}
+	return m
+}
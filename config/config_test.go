@@ -0,0 +1,414 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/beats/libbeat/common"
+	"github.com/elastic/beats/libbeat/paths"
+)
+
+// validationTest changes the default configuration and expects Validate to refuse it with an
+// error containing want, or to accept it if want is empty
+type validationTest struct {
+	name   string
+	modify func(*Config)
+	want   string
+}
+
+func runValidationTests(t *testing.T, tests []validationTest) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			test.modify(&cfg)
+			err := cfg.Validate()
+			switch {
+			case test.want == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)):
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateFSS(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"key file without acknowledging argv", func(c *Config) {
+			c.VerifyFSS = true
+			c.FSSKeyFile = "/etc/journalbeat/fss.key"
+		}, "fss_key_in_argv"},
+		{"key file with acknowledging argv", func(c *Config) {
+			c.VerifyFSS = true
+			c.FSSKeyFile = "/etc/journalbeat/fss.key"
+			c.FSSKeyInArgv = true
+		}, ""},
+		{"without key file", func(c *Config) { c.VerifyFSS = true }, ""},
+		{"invalid failure mode", func(c *Config) { c.FSSOnFailure = "ignore" }, "Invalid FSS On Failure"},
+	})
+}
+
+func TestValidateMessageField(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"default", func(c *Config) {}, ""},
+		{"other field", func(c *Config) { c.MessageField = "SYSLOG_RAW" }, ""},
+		{"empty", func(c *Config) { c.MessageField = "" }, "Invalid Message Field"},
+	})
+}
+
+// unpack unpacks the YAML configuration into a copy of the default configuration
+func unpack(t *testing.T, yaml string) Config {
+	t.Helper()
+	raw, err := common.NewConfigWithYAML([]byte(yaml), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	if err := raw.Unpack(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestUnpackMessageFieldDefault(t *testing.T) {
+	if got := unpack(t, "seek_position: tail").MessageField; got != "MESSAGE" {
+		t.Errorf("got message field %q, want MESSAGE", got)
+	}
+}
+
+func TestUnpackDefaultMaps(t *testing.T) {
+	configured := unpack(t, `
+sanitize_keys:
+  "-": "_"
+level_tokens:
+  OOPS: error
+`)
+	if want := map[string]string{"-": "_"}; !reflect.DeepEqual(configured.SanitizeKeys, want) {
+		t.Errorf("sanitize keys: got %v, want %v", configured.SanitizeKeys, want)
+	}
+	if want := map[string]string{"OOPS": "error"}; !reflect.DeepEqual(configured.LevelTokens, want) {
+		t.Errorf("level tokens: got %v, want %v", configured.LevelTokens, want)
+	}
+
+	// the first configuration must not have changed the defaults of the next one
+	defaults := unpack(t, "seek_position: tail")
+	if !reflect.DeepEqual(defaults.SanitizeKeys, defaultSanitizeKeys) {
+		t.Errorf("sanitize keys: got %v, want %v", defaults.SanitizeKeys, defaultSanitizeKeys)
+	}
+	if !reflect.DeepEqual(defaults.PriorityMap, defaultPriorityMap) {
+		t.Errorf("priority map: got %v, want %v", defaults.PriorityMap, defaultPriorityMap)
+	}
+	if !reflect.DeepEqual(defaults.LevelTokens, defaultLevelTokens) {
+		t.Errorf("level tokens: got %v, want %v", defaults.LevelTokens, defaultLevelTokens)
+	}
+
+	// nor may changing the maps of a configuration
+	defaults.SanitizeKeys["/"] = "_"
+	if _, ok := unpack(t, "seek_position: tail").SanitizeKeys["/"]; ok {
+		t.Error("changing the sanitize keys of a configuration changed the defaults")
+	}
+}
+
+func TestUnpackPriorityMap(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want PriorityNames
+	}{
+		{"numeric keys", `priority_map: {"3": err, "6": informational}`, PriorityNames{"3": "err", "6": "informational"}},
+		{"mixed keys", `priority_map: {"6": informational, "x": unknown}`, PriorityNames{"6": "informational", "x": "unknown"}},
+		{"list", `priority_map: [emerg, alert]`, PriorityNames{"0": "emerg", "1": "alert"}},
+		{"default", `seek_position: tail`, defaultPriorityMap},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := unpack(t, test.yaml).PriorityMap; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateFilterMode(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"or", func(c *Config) { c.FilterMode = FilterModeOr }, ""},
+		{"and", func(c *Config) { c.FilterMode = FilterModeAnd }, ""},
+		{"unknown", func(c *Config) { c.FilterMode = "xor" }, "Invalid Filter Mode"},
+	})
+}
+
+func TestValidateNamespaceMode(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"single", func(c *Config) { c.JournalNamespace = "app" }, ""},
+		{"all", func(c *Config) { c.NamespaceMode = NamespaceModeAll }, ""},
+		{"include default", func(c *Config) {
+			c.NamespaceMode = NamespaceModeIncludeDefault
+			c.JournalNamespace = "app"
+		}, ""},
+		{"include default without namespace", func(c *Config) { c.NamespaceMode = NamespaceModeIncludeDefault }, "requires a journal namespace"},
+		{"namespace with paths", func(c *Config) {
+			c.JournalNamespace = "app"
+			c.JournalPaths = []string{"/var/log/journal"}
+		}, "can not be combined with journal paths"},
+		{"unknown", func(c *Config) { c.NamespaceMode = "some" }, "Invalid Namespace Mode"},
+	})
+}
+
+func TestValidateCursorStore(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"file", func(c *Config) { c.CursorBackupFile = "backup" }, ""},
+		{"http without url", func(c *Config) { c.CursorStore = CursorStoreHTTP }, "requires a cursor store url"},
+		{"http", func(c *Config) {
+			c.CursorStore = CursorStoreHTTP
+			c.CursorStoreURL = "https://cursors.example.com/host1"
+		}, ""},
+		{"http with state backups", func(c *Config) {
+			c.CursorStore = CursorStoreHTTP
+			c.CursorStoreURL = "https://cursors.example.com/host1"
+			c.CursorStateBackups = 2
+		}, "Cursor state backups"},
+		{"http with backup file", func(c *Config) {
+			c.CursorStore = CursorStoreHTTP
+			c.CursorStoreURL = "https://cursors.example.com/host1"
+			c.CursorBackupFile = "backup"
+		}, "Cursor backup file"},
+		{"unknown", func(c *Config) { c.CursorStore = "s3" }, "Invalid Cursor Store"},
+	})
+}
+
+func TestValidateFileInputOffsetFile(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.CursorStateFile = "/var/lib/journalbeat/cursor-state"
+	cfg.FileInputs = []FileInput{
+		{Path: "/var/log/app/app.log"},
+		{Path: "/var/log/other.log", OffsetFile: "/tmp/other-offset"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/var/lib/journalbeat/.journalbeat-offset-var-log-app-app.log", "/tmp/other-offset"}
+	for i, input := range cfg.FileInputs {
+		if input.OffsetFile != want[i] {
+			t.Errorf("got offset file %s, want %s", input.OffsetFile, want[i])
+		}
+	}
+}
+
+func TestValidateTimestampUnit(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"guessed", func(c *Config) { c.TimestampUnit = "" }, ""},
+		{"nanoseconds", func(c *Config) { c.TimestampUnit = TimestampUnitNanoseconds }, ""},
+		{"unknown", func(c *Config) { c.TimestampUnit = "fortnights" }, "Invalid Timestamp Unit"},
+	})
+}
+
+func TestValidateExcludePriorities(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"valid", func(c *Config) { c.ExcludePriorities = []int{0, 7} }, ""},
+		{"too high", func(c *Config) { c.ExcludePriorities = []int{6, 8} }, "Invalid Priority to exclude: 8"},
+		{"negative", func(c *Config) { c.ExcludePriorities = []int{-1} }, "Invalid Priority to exclude: -1"},
+	})
+}
+
+func TestValidateResolvesCursorFilesAgainstDataPath(t *testing.T) {
+	previous := *paths.Paths
+	defer func() { *paths.Paths = previous }()
+	*paths.Paths = paths.Path{Data: "/var/lib/journalbeat"}
+
+	tests := []struct {
+		file, want string
+	}{
+		{".journalbeat-cursor-state", "/var/lib/journalbeat/.journalbeat-cursor-state"},
+		{"cursors/state", "/var/lib/journalbeat/cursors/state"},
+		{"/run/journalbeat/state", "/run/journalbeat/state"},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig
+		cfg.CursorStateFile = test.file
+		cfg.CursorBackupFile = test.file + ".backup"
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.CursorStateFile != test.want {
+			t.Errorf("got cursor state file %s, want %s", cfg.CursorStateFile, test.want)
+		}
+		if cfg.CursorBackupFile != test.want+".backup" {
+			t.Errorf("got cursor backup file %s, want %s.backup", cfg.CursorBackupFile, test.want)
+		}
+	}
+}
+
+func TestValidateBinaryFieldHandling(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"hex", func(c *Config) { c.BinaryFieldHandling = BinaryFieldHandlingHex }, ""},
+		{"drop", func(c *Config) { c.BinaryFieldHandling = BinaryFieldHandlingDrop }, ""},
+		{"unknown", func(c *Config) { c.BinaryFieldHandling = "escape" }, "Invalid Binary Field Handling"},
+	})
+}
+
+func TestValidateMoveMetadataLocation(t *testing.T) {
+	var tests []validationTest
+	for _, location := range []string{"", "journal", "journal.fields", "j_1.meta data"} {
+		location := location
+		tests = append(tests, validationTest{location, func(c *Config) { c.MoveMetadataLocation = location }, ""})
+	}
+	for _, location := range []string{".journal", "journal.", "journal..fields", " ", "journal. .fields", "journal.\t"} {
+		location := location
+		tests = append(tests, validationTest{location, func(c *Config) { c.MoveMetadataLocation = location }, "Wrong location"})
+	}
+	// the mapped fields and the cursor field follow the same rules
+	tests = append(tests,
+		validationTest{"mapped field", func(c *Config) { c.FieldMapping = map[string]string{"_PID": "process.pid"} }, ""},
+		validationTest{"mapped field with a leading dot", func(c *Config) { c.FieldMapping = map[string]string{"_PID": ".pid"} }, "Invalid Field Mapping"},
+		validationTest{"cursor field with a blank name", func(c *Config) {
+			c.IncludeCursor = true
+			c.CursorField = "journal. .cursor"
+		}, "Invalid Cursor Field"},
+	)
+	runValidationTests(t, tests)
+}
+
+func TestValidateCursorFsync(t *testing.T) {
+	tests := []struct {
+		durable, fsync, want bool
+	}{
+		{false, false, false},
+		{false, true, true},
+		{true, false, true},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig
+		cfg.DurableWrites = test.durable
+		cfg.CursorFsync = test.fsync
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.CursorFsync != test.want {
+			t.Errorf("durable_writes %v, cursor_fsync %v: got cursor_fsync %v, want %v", test.durable, test.fsync, cfg.CursorFsync, test.want)
+		}
+	}
+	if !DefaultConfig.CursorFsync {
+		t.Error("the cursor is not synced by default")
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"ecs", func(c *Config) { c.OutputFormat = OutputFormatECS }, ""},
+		{"gelf", func(c *Config) { c.OutputFormat = OutputFormatGELF }, ""},
+		{"unknown", func(c *Config) { c.OutputFormat = "cef" }, "Invalid Output Format"},
+	})
+}
+
+func TestValidateOriginalFieldsKey(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"default", func(c *Config) { c.KeepOriginalFields = true }, ""},
+		{"nested", func(c *Config) {
+			c.KeepOriginalFields = true
+			c.OriginalFieldsKey = "journal.original"
+		}, ""},
+		{"empty", func(c *Config) {
+			c.KeepOriginalFields = true
+			c.OriginalFieldsKey = ""
+		}, "Invalid Original Fields Key"},
+		{"empty segment", func(c *Config) {
+			c.KeepOriginalFields = true
+			c.OriginalFieldsKey = "journal..original"
+		}, "Invalid Original Fields Key"},
+		{"not kept", func(c *Config) { c.OriginalFieldsKey = "" }, ""},
+	})
+}
+
+func TestValidatePriorityField(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"default", func(c *Config) { c.ParsePriority = true }, ""},
+		{"other field", func(c *Config) {
+			c.ParsePriority = true
+			c.PriorityField = "syslog.severity"
+		}, ""},
+		{"empty", func(c *Config) {
+			c.ParsePriority = true
+			c.PriorityField = ""
+		}, "Invalid Priority Field"},
+		{"trailing dot", func(c *Config) {
+			c.ParsePriority = true
+			c.PriorityField = "log."
+		}, "Invalid Priority Field"},
+		{"not parsed", func(c *Config) { c.PriorityField = "" }, ""},
+	})
+}
+
+func TestUnpackMaxMessageChars(t *testing.T) {
+	if got := unpack(t, "max_message_chars: 100").MaxMessageChars; got != 100 {
+		t.Errorf("got max message chars %d, want 100", got)
+	}
+
+	raw, err := common.NewConfigWithYAML([]byte("max_message_chars: -1"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig
+	if err := raw.Unpack(&cfg); err == nil {
+		t.Error("a negative max_message_chars was accepted")
+	}
+}
+
+func TestValidatePendingQueueFile(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.PendingQueue.File = ""
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PendingQueue.File != "" {
+		t.Errorf("got pending queue file %q, want it to stay off", cfg.PendingQueue.File)
+	}
+
+	cfg = DefaultConfig
+	cfg.PendingQueue.File = "pending"
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(cfg.PendingQueue.File) {
+		t.Errorf("got pending queue file %q, want an absolute path", cfg.PendingQueue.File)
+	}
+}
+
+func TestValidatePublishMode(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"guaranteed", func(c *Config) { c.PublishMode = PublishModeGuaranteed }, ""},
+		{"async", func(c *Config) { c.PublishMode = PublishModeAsync }, ""},
+		{"drop if full", func(c *Config) {
+			c.PublishMode = PublishModeDropIfFull
+			c.MaxPending = 100
+		}, ""},
+		{"drop if full without max pending", func(c *Config) { c.PublishMode = PublishModeDropIfFull }, "requires max_pending"},
+		{"unknown", func(c *Config) { c.PublishMode = "fire_and_forget" }, "Invalid Publish Mode"},
+	})
+}
+
+func TestValidatePriorityFieldForLevelFromMessage(t *testing.T) {
+	runValidationTests(t, []validationTest{
+		{"default", func(c *Config) { c.LevelFromMessage = true }, ""},
+		{"empty", func(c *Config) {
+			c.LevelFromMessage = true
+			c.PriorityField = ""
+		}, "Invalid Priority Field"},
+	})
+}
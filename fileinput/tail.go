@@ -0,0 +1,215 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fileinput provides a minimal tailer for classic log files which are
+// published alongside the journal entries.
+package fileinput
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/beats/libbeat/logp"
+)
+
+const (
+	pollPeriod       = 250 * time.Millisecond
+	offsetSavePeriod = 1 * time.Second
+)
+
+// Line is a line read from a tailed file
+type Line struct {
+	Path   string
+	Text   string
+	Offset int64
+	Time   time.Time
+}
+
+// Tail follows the file at path much like tail -F and writes its lines to the output channel.
+// Reading starts at the offset saved in offsetFile, which is updated as lines are handed over.
+// Truncated files are read again from the beginning, rotated files are reopened.
+func Tail(path, offsetFile string, stop <-chan struct{}) <-chan *Line {
+	out := make(chan *Line)
+
+	go func() {
+		defer close(out)
+
+		offset := loadOffset(offsetFile)
+		savedOffset := offset
+		lastSave := time.Now()
+		saveOffset := func() {
+			if offset == savedOffset {
+				return
+			}
+			if err := writeOffset(offsetFile, offset); err != nil {
+				logp.Err("Could not save the offset of %s: %v", path, err)
+				return
+			}
+			savedOffset = offset
+			lastSave = time.Now()
+		}
+		defer saveOffset()
+
+		var file *os.File
+		var reader *bufio.Reader
+		var partial string
+		defer func() {
+			if file != nil {
+				_ = file.Close()
+			}
+		}()
+
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if file == nil {
+				var err error
+				if file, err = os.Open(path); err != nil {
+					logp.Debug("fileinput", "Could not open %s: %v", path, err)
+					if !wait(stop) {
+						return
+					}
+					continue
+				}
+				if fi, err := file.Stat(); err == nil && fi.Size() < offset {
+					logp.Info("File %s was truncated, reading from the beginning", path)
+					offset = 0
+				}
+				if _, err := file.Seek(offset, io.SeekStart); err != nil {
+					logp.Err("Could not seek to offset %d of %s: %v", offset, path, err)
+					offset = 0
+				}
+				reader = bufio.NewReader(file)
+				partial = ""
+			}
+
+			text, err := reader.ReadString('\n')
+			if err == nil {
+				text = partial + text
+				partial = ""
+				line := &Line{
+					Path:   path,
+					Text:   strings.TrimRight(text, "\r\n"),
+					Offset: offset,
+					Time:   time.Now(),
+				}
+				select {
+				case <-stop:
+					return
+				case out <- line:
+				}
+				offset += int64(len(text))
+				if time.Since(lastSave) >= offsetSavePeriod {
+					saveOffset()
+				}
+				continue
+			}
+
+			if err != io.EOF {
+				logp.Err("Reading %s failed: %v", path, err)
+			}
+			// keep incomplete lines until they are terminated
+			partial += text
+
+			saveOffset()
+			if !wait(stop) {
+				return
+			}
+
+			// start over with the new file if it was rotated or truncated
+			if rotated(file, path, offset+int64(len(partial))) {
+				_ = file.Close()
+				file = nil
+				offset = 0
+			}
+		}
+	}()
+
+	return out
+}
+
+// wait waits for the poll period and returns false if stop was closed in the meantime
+func wait(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-time.After(pollPeriod):
+		return true
+	}
+}
+
+// rotated returns true if path does not refer to the opened file anymore or the file
+// shrank below the position read so far
+func rotated(file *os.File, path string, position int64) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	ofi, err := file.Stat()
+	if err != nil {
+		return true
+	}
+
+	if !os.SameFile(fi, ofi) {
+		logp.Info("File %s was rotated, reopening", path)
+		return true
+	}
+
+	if fi.Size() < position {
+		logp.Info("File %s was truncated, reading from the beginning", path)
+		return true
+	}
+	return false
+}
+
+func loadOffset(offsetFile string) int64 {
+	data, err := ioutil.ReadFile(offsetFile)
+	if err != nil {
+		return 0
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || offset < 0 {
+		logp.Warn("Invalid offset in %s, reading from the beginning", offsetFile)
+		return 0
+	}
+	return offset
+}
+
+func writeOffset(offsetFile string, offset int64) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(offsetFile), fmt.Sprintf(".%s", filepath.Base(offsetFile)))
+	if err != nil {
+		return err
+	}
+
+	if _, err = tempFile.WriteString(strconv.FormatInt(offset, 10)); err != nil {
+		_ = tempFile.Close()
+		return err
+	}
+
+	_ = tempFile.Close()
+	return os.Rename(tempFile.Name(), offsetFile)
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fileinput
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// receive returns the text of the next line, failing the test if there is none in time
+func receive(t *testing.T, lines <-chan *Line) string {
+	select {
+	case line, ok := <-lines:
+		if !ok {
+			t.Fatal("tailing ended")
+		}
+		return line.Text
+	case <-time.After(5 * time.Second):
+		t.Fatal("no line was read")
+	}
+	return ""
+}
+
+func appendFile(t *testing.T, path, text string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileinput")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	offsetFile := filepath.Join(dir, "offset")
+
+	appendFile(t, path, "one\r\ntwo\nthr")
+	stop := make(chan struct{})
+	lines := Tail(path, offsetFile, stop)
+
+	for _, want := range []string{"one", "two"} {
+		if got := receive(t, lines); got != want {
+			t.Errorf("got line %q, want %q", got, want)
+		}
+	}
+	// an incomplete line is only handed over once it is terminated
+	appendFile(t, path, "ee\n")
+	if got := receive(t, lines); got != "three" {
+		t.Errorf("got line %q, want three", got)
+	}
+
+	// a rotated file is read from its beginning
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "four\n")
+	if got := receive(t, lines); got != "four" {
+		t.Errorf("got line %q after the rotation, want four", got)
+	}
+
+	close(stop)
+	for range lines {
+	}
+	if offset := loadOffset(offsetFile); offset != int64(len("four\n")) {
+		t.Errorf("got saved offset %d, want %d", offset, len("four\n"))
+	}
+
+	// tailing again goes on at the saved offset
+	appendFile(t, path, "five\n")
+	stop = make(chan struct{})
+	defer close(stop)
+	if got := receive(t, Tail(path, offsetFile, stop)); got != "five" {
+		t.Errorf("got line %q after the restart, want five", got)
+	}
+}
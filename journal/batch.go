@@ -0,0 +1,54 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// Batch groups the entries read from in into batches of up to size entries. A batch is
+// handed over as soon as no further entry is ready, so entries do not wait for a full
+// batch once the tail of the journal is reached.
+func Batch(in <-chan *sdjournal.JournalEntry, size int, stop <-chan struct{}) <-chan []*sdjournal.JournalEntry {
+	out := make(chan []*sdjournal.JournalEntry)
+
+	go func() {
+		defer close(out)
+
+		for entry := range in {
+			batch := []*sdjournal.JournalEntry{entry}
+		fill:
+			for len(batch) < size {
+				select {
+				case entry, ok := <-in:
+					if !ok {
+						break fill
+					}
+					batch = append(batch, entry)
+				default:
+					break fill
+				}
+			}
+
+			select {
+			case <-stop:
+				return
+			case out <- batch:
+			}
+		}
+	}()
+
+	return out
+}
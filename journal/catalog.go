@@ -0,0 +1,143 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// Catalog looks up the catalog entries of the journal entries with a MESSAGE_ID. A nil Catalog
+// does no lookups at all.
+//
+// With a cache, the catalog text is cached per MESSAGE_ID. A cached text can not have the fields
+// of the entry substituted as those vary from entry to entry, so CATALOG_ENTRY holds the raw
+// catalog text then, just like CATALOG_ENTRY_RAW.
+type Catalog struct {
+	raw      bool
+	sanitize bool
+
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	cache map[string]*list.Element
+}
+
+type catalogCacheEntry struct {
+	messageID string
+	text      string
+}
+
+// NewCatalog creates a Catalog. If raw is set, the untemplated catalog entry is added next to the
+// substituted one. cacheSize is the number of message IDs to cache, zero disables the cache.
+// If sanitize is set, the control characters of the catalog entries are escaped.
+func NewCatalog(raw bool, cacheSize int, sanitize bool) *Catalog {
+	return &Catalog{
+		raw:      raw,
+		sanitize: sanitize,
+		size:     cacheSize,
+		order:    list.New(),
+		cache:    map[string]*list.Element{},
+	}
+}
+
+// text returns the catalog text to add to the entry
+func (c *Catalog) text(catalogEntry string) string {
+	if !c.sanitize {
+		return catalogEntry
+	}
+	return sanitizeCatalog(catalogEntry)
+}
+
+// sanitizeCatalog escapes the control characters like NULs which the substituted fields may
+// bring into the catalog text as \xNN. Newlines and tabs are kept, they format the catalog text.
+func sanitizeCatalog(catalogEntry string) string {
+	var b strings.Builder
+	for _, r := range catalogEntry {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			fmt.Fprintf(&b, "\\x%02x", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// addTo adds the catalog entry of the message ID to the fields of the current entry of journal
+func (c *Catalog) addTo(journal EntrySource, messageID string, fields map[string]string) {
+	if c.size > 0 {
+		catalogEntry, err := c.cached(messageID)
+		if err != nil {
+			fields[SD_JOURNAL_FIELD_PROCESSING_ERROR] = fmt.Sprintf("catalog lookup failed: %v", err)
+		} else if catalogEntry != "" {
+			fields[SD_JOURNAL_FIELD_CATALOG_ENTRY] = catalogEntry
+			if c.raw {
+				fields[SD_JOURNAL_FIELD_CATALOG_ENTRY_RAW] = catalogEntry
+			}
+		}
+		return
+	}
+
+	if catalogEntry, err := journal.GetCatalog(); err == nil {
+		fields[SD_JOURNAL_FIELD_CATALOG_ENTRY] = c.text(catalogEntry)
+	} else if !catalogNotFound(err) {
+		fields[SD_JOURNAL_FIELD_PROCESSING_ERROR] = fmt.Sprintf("catalog lookup failed: %v", err)
+	}
+	if c.raw {
+		if catalogEntry, err := sdjournal.GetCatalogForMessageID(messageID); err == nil {
+			fields[SD_JOURNAL_FIELD_CATALOG_ENTRY_RAW] = c.text(catalogEntry)
+		} else if !catalogNotFound(err) {
+			fields[SD_JOURNAL_FIELD_PROCESSING_ERROR] = fmt.Sprintf("raw catalog lookup failed: %v", err)
+		}
+	}
+}
+
+// cached returns the raw catalog text of the message ID from the cache, looking it up on a miss.
+// A message ID without a catalog entry is cached with an empty text, failed lookups are not cached.
+func (c *Catalog) cached(messageID string) (string, error) {
+	c.mu.Lock()
+	if e, ok := c.cache[messageID]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*catalogCacheEntry).text, nil
+	}
+	c.mu.Unlock()
+
+	text, err := sdjournal.GetCatalogForMessageID(messageID)
+	if err != nil {
+		if !catalogNotFound(err) {
+			return "", err
+		}
+		text = ""
+	}
+	text = c.text(text)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[messageID]; !ok {
+		c.cache[messageID] = c.order.PushFront(&catalogCacheEntry{messageID: messageID, text: text})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.cache, oldest.Value.(*catalogCacheEntry).messageID)
+		}
+	}
+	return text, nil
+}
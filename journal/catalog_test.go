@@ -0,0 +1,40 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import "testing"
+
+func TestCatalogText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"lines\n\tindented", "lines\n\tindented"},
+		{"nul \x00 and escape \x1b[0m", `nul \x00 and escape \x1b[0m`},
+		{"carriage\r return and \x7f delete", `carriage\x0d return and \x7f delete`},
+		{"unicode ünd \u0085 next line", `unicode ünd \x85 next line`},
+	}
+	sanitized := NewCatalog(false, 0, true)
+	unsanitized := NewCatalog(false, 0, false)
+	for _, test := range tests {
+		if got := sanitized.text(test.text); got != test.want {
+			t.Errorf("sanitized %q: got %q, want %q", test.text, got, test.want)
+		}
+		if got := unsanitized.text(test.text); got != test.text {
+			t.Errorf("unsanitized %q: got %q, want it unchanged", test.text, got)
+		}
+	}
+}
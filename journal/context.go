@@ -0,0 +1,79 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// SD_JOURNAL_FIELD_CONTEXT stores the name of the JournalEntry field to export the messages of
+// the entries before the entry to, as a JSON array. It is not part of the journal entry.
+const SD_JOURNAL_FIELD_CONTEXT = "JOURNALBEAT_CONTEXT"
+
+// previousEntriesSource is the part of *sdjournal.Journal needed to read the entries before
+// the current one
+type previousEntriesSource interface {
+	PreviousEntries(n uint64) ([]*sdjournal.JournalEntry, error)
+}
+
+// ContextLines adds the messages of the entries before an entry of the max priority or a more
+// severe one to the entry
+type ContextLines struct {
+	before      uint64
+	maxPriority int
+}
+
+// NewContextLines returns the context lines adding the messages of up to before entries
+func NewContextLines(before uint64, maxPriority int) *ContextLines {
+	return &ContextLines{before: before, maxPriority: maxPriority}
+}
+
+// addTo adds the messages of the entries before the current entry of journal to its fields, if
+// the priority of the entry triggers it. A source without the entries before is skipped.
+func (c *ContextLines) addTo(journal EntrySource, fields map[string]string) {
+	priority, err := strconv.Atoi(strings.TrimSpace(fields[sdjournal.SD_JOURNAL_FIELD_PRIORITY]))
+	if err != nil || priority > c.maxPriority {
+		return
+	}
+	source, ok := journal.(previousEntriesSource)
+	if !ok {
+		return
+	}
+
+	entries, err := source.PreviousEntries(c.before)
+	if err != nil {
+		fields[SD_JOURNAL_FIELD_PROCESSING_ERROR] = fmt.Sprintf("reading the context lines failed: %v", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	lines := make([]string, len(entries))
+	for i, entry := range entries {
+		lines[i] = entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE]
+	}
+	encoded, err := json.Marshal(lines)
+	if err != nil {
+		fields[SD_JOURNAL_FIELD_PROCESSING_ERROR] = fmt.Sprintf("encoding the context lines failed: %v", err)
+		return
+	}
+	fields[SD_JOURNAL_FIELD_CONTEXT] = string(encoded)
+}
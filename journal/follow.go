@@ -15,20 +15,100 @@
 package journal
 
 import (
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/coreos/go-systemd/sdjournal"
 	"github.com/elastic/beats/libbeat/logp"
+	"github.com/mheese/journalbeat/journal/sdjournal"
 )
 
 // SD_JOURNAL_FIELD_CATALOG_ENTRY stores the name of the JournalEntry field to export Catalog entry to.
 const SD_JOURNAL_FIELD_CATALOG_ENTRY = "CATALOG_ENTRY"
 
+// SD_JOURNAL_FIELD_CATALOG_ENTRY_RAW stores the name of the JournalEntry field to export the
+// Catalog entry to without the fields of the entry substituted.
+const SD_JOURNAL_FIELD_CATALOG_ENTRY_RAW = "CATALOG_ENTRY_RAW"
+
+// SD_JOURNAL_FIELD_PROCESSING_ERROR stores the name of the JournalEntry field to export
+// the error of a failed processing step to. It is not part of the journal entry.
+const SD_JOURNAL_FIELD_PROCESSING_ERROR = "JOURNALBEAT_PROCESSING_ERROR"
+
+// SD_JOURNAL_FIELD_SOURCE stores the name of the JournalEntry field to export the name of the
+// journal source the entry was read from to. It is not part of the journal entry.
+const SD_JOURNAL_FIELD_SOURCE = "JOURNALBEAT_SOURCE"
+
+// SD_JOURNAL_FIELD_HEARTBEAT stores the name of the JournalEntry field marking a heartbeat
+// entry, handed over while the journal is idle. It holds the number of entries handed over
+// since the previous heartbeat; the cursor of the heartbeat is the cursor of the last entry.
+const SD_JOURNAL_FIELD_HEARTBEAT = "JOURNALBEAT_HEARTBEAT"
+
+// maxReadErrors is the number of consecutive failed reads after which following ends
+const maxReadErrors = 10
+
+// readAhead is the number of entries read at once from an entriesSource
+const readAhead = 64
+
+// EntrySource is the part of *sdjournal.Journal which Follow reads the entries from. Other
+// sources, e.g. scripted entries, can be followed without a journal behind them.
+type EntrySource interface {
+	Next() (uint64, error)
+	GetEntry() (*sdjournal.JournalEntry, error)
+	GetCursor() (string, error)
+	GetCatalog() (string, error)
+	Wait(timeout time.Duration) int
+}
+
+// entriesSource is implemented by the sources reading several entries at once, like
+// *sdjournal.Journal does
+type entriesSource interface {
+	GetEntries(max int) ([]*sdjournal.JournalEntry, error)
+}
+
+// catalogNotFound reports whether a catalog lookup failed only because there is
+// no catalog entry for the message ID (ENOENT)
+func catalogNotFound(err error) bool {
+	return strings.HasSuffix(err.Error(), fmt.Sprintf(": %d", syscall.ENOENT))
+}
+
 // Follow follows the journald and writes the entries to the output channel
 // It is a slightly reworked version of sdjournal.Follow to fit our needs.
-func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.JournalEntry {
-	readEntry := func(journal *sdjournal.Journal) (*sdjournal.JournalEntry, error) {
+// The catalog entries are looked up by catalog, which may be nil to skip the lookups. Likewise
+// the context lines are added by context, which may be nil.
+// While the journal is idle, a heartbeat entry is handed over every heartbeat period, unless
+// the period is zero.
+// Sources implementing entriesSource are read readAhead entries at once, unless the catalog
+// without a cache or the context lines need the read pointer at the entry handed over.
+func Follow(journal EntrySource, stop <-chan struct{}, catalog *Catalog, context *ContextLines, heartbeatPeriod time.Duration) <-chan *sdjournal.JournalEntry {
+	bulk, _ := journal.(entriesSource)
+	if context != nil || (catalog != nil && catalog.size == 0) {
+		bulk = nil
+	}
+	var ahead []*sdjournal.JournalEntry
+
+	readEntry := func(journal EntrySource) (*sdjournal.JournalEntry, error) {
+		if bulk != nil {
+			if len(ahead) == 0 {
+				entries, err := bulk.GetEntries(readAhead)
+				if len(entries) == 0 {
+					if err != nil {
+						return nil, err
+					}
+					return nil, io.EOF
+				}
+				// the entries read before an error are handed over first, the next read
+				// fails again if the journal is still unusable
+				ahead = entries
+			}
+			entry := ahead[0]
+			ahead = ahead[1:]
+			return entry, nil
+		}
+
 		c, err := journal.Next()
 		if err != nil {
 			return nil, err
@@ -47,9 +127,27 @@ func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.
 
 	out := make(chan *sdjournal.JournalEntry)
 
-	go func(journal *sdjournal.Journal, stop <-chan struct{}, out chan<- *sdjournal.JournalEntry) {
+	go func(journal EntrySource, stop <-chan struct{}, out chan<- *sdjournal.JournalEntry) {
 		defer close(out)
+		// a panic ends following like a stop does, the caller can tell them apart by its stop channel
+		defer func() {
+			if r := recover(); r != nil {
+				logp.Err("Following the journal panicked: %v", r)
+			}
+		}()
+		// the output is only closed once the journal is not waited on anymore, so the journal
+		// can be closed after following ended
+		var waiters sync.WaitGroup
+		defer waiters.Wait()
 		eventWaitCh := make(chan int)
+		// a panic while waiting ends following too, there is at most one waiter at a time
+		waitPanicked := make(chan struct{}, 1)
+		readErrors := 0
+
+		// state of the heartbeats
+		var cursor string
+		entries := 0
+		lastHeartbeat := time.Now()
 
 	process:
 		for {
@@ -66,20 +164,29 @@ func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.
 				} else {
 					logp.Warn("Received unknown error when reading a new entry: %v, cursor: %s", err, cursor)
 				}
+				// the journal handle is most likely unusable, e.g. after journald was restarted
+				if readErrors++; readErrors >= maxReadErrors {
+					logp.Err("Reading the journal failed %d times in a row, giving up", readErrors)
+					return
+				}
 				continue
 			}
+			readErrors = 0
 
 			if entry != nil {
-				if _, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE_ID]; ok {
-					if catalogEntry, err := journal.GetCatalog(); err == nil {
-						entry.Fields[SD_JOURNAL_FIELD_CATALOG_ENTRY] = catalogEntry
-					}
+				if messageID, ok := entry.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE_ID]; ok && catalog != nil {
+					catalog.addTo(journal, messageID, entry.Fields)
+				}
+				if context != nil {
+					context.addTo(journal, entry.Fields)
 				}
 				// non-blocking return
 				select {
 				case <-stop:
 					return
 				case out <- entry:
+					cursor = entry.Cursor
+					entries++
 					continue process
 				}
 			}
@@ -88,7 +195,15 @@ func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.
 			// Holds journal events to process. Tightly bounded for now unless there's a
 			// reason to unblock the journal watch routine more quickly.
 			for {
+				waiters.Add(1)
 				go func() {
+					defer waiters.Done()
+					defer func() {
+						if r := recover(); r != nil {
+							logp.Err("Waiting for the journal panicked: %v", r)
+							waitPanicked <- struct{}{}
+						}
+					}()
 					select {
 					case <-stop:
 					case eventWaitCh <- journal.Wait(100 * time.Millisecond):
@@ -98,10 +213,27 @@ func Follow(journal *sdjournal.Journal, stop <-chan struct{}) <-chan *sdjournal.
 				select {
 				case <-stop:
 					return
+				case <-waitPanicked:
+					return
 				case e := <-eventWaitCh:
 					switch e {
 					case sdjournal.SD_JOURNAL_NOP:
 						// the journal did not change since the last invocation
+						if heartbeatPeriod <= 0 || time.Since(lastHeartbeat) < heartbeatPeriod {
+							continue
+						}
+						heartbeat := &sdjournal.JournalEntry{
+							Fields:            map[string]string{SD_JOURNAL_FIELD_HEARTBEAT: strconv.Itoa(entries)},
+							Cursor:            cursor,
+							RealtimeTimestamp: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+						}
+						select {
+						case <-stop:
+							return
+						case out <- heartbeat:
+							entries = 0
+							lastHeartbeat = time.Now()
+						}
 					case sdjournal.SD_JOURNAL_APPEND, sdjournal.SD_JOURNAL_INVALIDATE:
 						continue process
 					default:
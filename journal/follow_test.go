@@ -0,0 +1,386 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// fakeSource is an EntrySource handing over scripted entries. At the end of the entries Wait
+// reports appended entries, if any, or times out.
+type fakeSource struct {
+	mu      sync.Mutex
+	entries []*sdjournal.JournalEntry
+	next    int
+	current *sdjournal.JournalEntry
+	waits   int
+
+	// catalog holds the catalog texts by MESSAGE_ID
+	catalog map[string]string
+	// nextErr fails every read, panicNext and panicWait make the reads and the waits panic
+	nextErr   error
+	panicNext bool
+	panicWait bool
+}
+
+func newFakeSource(entries ...*sdjournal.JournalEntry) *fakeSource {
+	return &fakeSource{entries: entries}
+}
+
+// append adds entries to the end of the source
+func (s *fakeSource) append(entries ...*sdjournal.JournalEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entries...)
+}
+
+// waited returns the number of calls of Wait so far
+func (s *fakeSource) waited() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.waits
+}
+
+func (s *fakeSource) Next() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.panicNext {
+		panic("next")
+	}
+	if s.nextErr != nil {
+		return 0, s.nextErr
+	}
+	if s.next >= len(s.entries) {
+		return 0, nil
+	}
+	s.current = s.entries[s.next]
+	s.next++
+	return 1, nil
+}
+
+func (s *fakeSource) GetEntry() (*sdjournal.JournalEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current, nil
+}
+
+func (s *fakeSource) GetCursor() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return "", errors.New("no entry read yet")
+	}
+	return s.current.Cursor, nil
+}
+
+func (s *fakeSource) GetCatalog() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if text, ok := s.catalog[s.current.Fields[sdjournal.SD_JOURNAL_FIELD_MESSAGE_ID]]; ok {
+		return text, nil
+	}
+	// like the wrapper reports a message ID without a catalog entry
+	return "", fmt.Errorf("failed to retrieve catalog entry: %d", syscall.ENOENT)
+}
+
+func (s *fakeSource) Wait(timeout time.Duration) int {
+	s.mu.Lock()
+	s.waits++
+	if s.panicWait {
+		s.mu.Unlock()
+		panic("wait")
+	}
+	pending := s.next < len(s.entries)
+	s.mu.Unlock()
+	if pending {
+		return sdjournal.SD_JOURNAL_APPEND
+	}
+
+	time.Sleep(timeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.next < len(s.entries) {
+		return sdjournal.SD_JOURNAL_APPEND
+	}
+	return sdjournal.SD_JOURNAL_NOP
+}
+
+// bulkSource is a fakeSource which also reads several entries at once
+type bulkSource struct {
+	*fakeSource
+	bulkReads int
+}
+
+func (s *bulkSource) GetEntries(max int) ([]*sdjournal.JournalEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bulkReads++
+	var entries []*sdjournal.JournalEntry
+	for len(entries) < max && s.next < len(s.entries) {
+		s.current = s.entries[s.next]
+		s.next++
+		entries = append(entries, s.current)
+	}
+	return entries, nil
+}
+
+// reads returns the number of calls of GetEntries so far
+func (s *bulkSource) reads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bulkReads
+}
+
+func testEntry(cursor string, fields ...string) *sdjournal.JournalEntry {
+	entry := &sdjournal.JournalEntry{Cursor: cursor, Fields: map[string]string{}}
+	for i := 0; i+1 < len(fields); i += 2 {
+		entry.Fields[fields[i]] = fields[i+1]
+	}
+	return entry
+}
+
+// receive returns the next entry handed over by following
+func receive(t *testing.T, out <-chan *sdjournal.JournalEntry) *sdjournal.JournalEntry {
+	t.Helper()
+	select {
+	case entry, ok := <-out:
+		if !ok {
+			t.Fatal("following ended unexpectedly")
+		}
+		return entry
+	case <-time.After(5 * time.Second):
+		t.Fatal("no entry handed over")
+	}
+	return nil
+}
+
+// ended checks that following ends, dropping the entries still handed over
+func ended(t *testing.T, out <-chan *sdjournal.JournalEntry) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("following did not end")
+		}
+	}
+}
+
+func TestFollowHandsOverEntriesInOrder(t *testing.T) {
+	source := newFakeSource(testEntry("c1", "MESSAGE", "one"), testEntry("c2", "MESSAGE", "two"))
+	stop := make(chan struct{})
+	defer close(stop)
+
+	out := Follow(source, stop, nil, nil, 0)
+	for _, cursor := range []string{"c1", "c2"} {
+		if entry := receive(t, out); entry.Cursor != cursor {
+			t.Errorf("got the entry with cursor %s, want %s", entry.Cursor, cursor)
+		}
+	}
+}
+
+func TestFollowReadsAhead(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog *Catalog
+		context *ContextLines
+		bulk    bool
+	}{
+		{"without catalog and context lines", nil, nil, true},
+		{"with a cached catalog", NewCatalog(false, 10, false), nil, true},
+		// both need the read pointer at the entry handed over
+		{"with an uncached catalog", NewCatalog(false, 0, false), nil, false},
+		{"with context lines", nil, NewContextLines(1, 3), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []*sdjournal.JournalEntry
+			for i := 0; i < readAhead+2; i++ {
+				entries = append(entries, testEntry(fmt.Sprintf("c%d", i)))
+			}
+			source := &bulkSource{fakeSource: newFakeSource(entries...)}
+			stop := make(chan struct{})
+			defer close(stop)
+
+			out := Follow(source, stop, test.catalog, test.context, 0)
+			for _, want := range entries {
+				if entry := receive(t, out); entry.Cursor != want.Cursor {
+					t.Fatalf("got the entry with cursor %s, want %s", entry.Cursor, want.Cursor)
+				}
+			}
+
+			// one read of readAhead entries, one of the rest
+			if reads := source.reads(); test.bulk && reads < 2 || !test.bulk && reads != 0 {
+				t.Errorf("got %d bulk reads, want bulk reads: %v", reads, test.bulk)
+			}
+		})
+	}
+}
+
+func TestFollowEndsOnStop(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*sdjournal.JournalEntry
+	}{
+		// the entry can not be handed over as nobody reads it
+		{"while handing over an entry", []*sdjournal.JournalEntry{testEntry("c1")}},
+		{"while waiting at the tail", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := newFakeSource(test.entries...)
+			stop := make(chan struct{})
+			out := Follow(source, stop, nil, nil, 0)
+
+			time.Sleep(50 * time.Millisecond)
+			close(stop)
+			select {
+			case _, ok := <-out:
+				if ok {
+					// a stop racing with handing over the entry may still hand it over
+					ended(t, out)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("following did not end on stop")
+			}
+		})
+	}
+}
+
+func TestFollowWaitsAtTheTail(t *testing.T) {
+	source := newFakeSource(testEntry("c1"))
+	stop := make(chan struct{})
+	defer close(stop)
+
+	out := Follow(source, stop, nil, nil, 0)
+	receive(t, out)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for source.waited() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("following did not wait for new entries at the tail")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	source.append(testEntry("c2"))
+	if entry := receive(t, out); entry.Cursor != "c2" {
+		t.Errorf("got the entry with cursor %s after waiting, want c2", entry.Cursor)
+	}
+}
+
+func TestFollowHeartbeat(t *testing.T) {
+	source := newFakeSource(testEntry("c1"), testEntry("c2"))
+	stop := make(chan struct{})
+	defer close(stop)
+
+	out := Follow(source, stop, nil, nil, time.Millisecond)
+	receive(t, out)
+	receive(t, out)
+
+	heartbeat := receive(t, out)
+	if heartbeat.Fields[SD_JOURNAL_FIELD_HEARTBEAT] != "2" {
+		t.Errorf("got the heartbeat %q, want 2 entries", heartbeat.Fields[SD_JOURNAL_FIELD_HEARTBEAT])
+	}
+	if heartbeat.Cursor != "c2" {
+		t.Errorf("got the heartbeat cursor %s, want c2", heartbeat.Cursor)
+	}
+}
+
+func TestFollowCatalog(t *testing.T) {
+	tests := []struct {
+		name      string
+		messageID string
+		want      string
+	}{
+		{"with catalog entry", "m1", "catalog of m1"},
+		{"control characters escaped", "m2", `nul \x00 kept` + "\n"},
+		{"without catalog entry", "m3", ""},
+	}
+
+	source := newFakeSource()
+	source.catalog = map[string]string{"m1": "catalog of m1", "m2": "nul \x00 kept\n"}
+	for i, test := range tests {
+		source.append(testEntry(fmt.Sprintf("c%d", i), sdjournal.SD_JOURNAL_FIELD_MESSAGE_ID, test.messageID))
+	}
+	source.append(testEntry("c-none", "MESSAGE", "no message ID"))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	out := Follow(source, stop, NewCatalog(false, 0, true), nil, 0)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := receive(t, out)
+			if got := entry.Fields[SD_JOURNAL_FIELD_CATALOG_ENTRY]; got != test.want {
+				t.Errorf("got the catalog entry %q, want %q", got, test.want)
+			}
+			if err, ok := entry.Fields[SD_JOURNAL_FIELD_PROCESSING_ERROR]; ok {
+				t.Errorf("got the processing error %q", err)
+			}
+		})
+	}
+
+	entry := receive(t, out)
+	if _, ok := entry.Fields[SD_JOURNAL_FIELD_CATALOG_ENTRY]; ok {
+		t.Error("an entry without message ID got a catalog entry")
+	}
+}
+
+func TestFollowEndsAfterReadErrors(t *testing.T) {
+	source := newFakeSource()
+	source.nextErr = errors.New("read failed")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	ended(t, Follow(source, stop, nil, nil, 0))
+}
+
+func TestFollowRecoversFromPanics(t *testing.T) {
+	tests := []struct {
+		name   string
+		source func() *fakeSource
+	}{
+		{"reading", func() *fakeSource {
+			source := newFakeSource(testEntry("c1"))
+			source.panicNext = true
+			return source
+		}},
+		{"waiting", func() *fakeSource {
+			source := newFakeSource()
+			source.panicWait = true
+			return source
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			defer close(stop)
+			// a panic which is not recovered ends the test binary
+			ended(t, Follow(test.source(), stop, nil, nil, 0))
+		})
+	}
+}
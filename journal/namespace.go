@@ -0,0 +1,84 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+// ScopeFlags maps a journal scope to the flags restricting which journal files are opened
+func ScopeFlags(scope string) (int, error) {
+	switch scope {
+	case config.JournalScopeAll:
+		return 0, nil
+	case config.JournalScopeRuntime:
+		return sdjournal.SD_JOURNAL_RUNTIME_ONLY, nil
+	case config.JournalScopeSystem:
+		return sdjournal.SD_JOURNAL_SYSTEM, nil
+	default:
+		return 0, fmt.Errorf("unknown journal scope: %s", scope)
+	}
+}
+
+// Open opens the local journal restricted to the given scope
+func Open(scope string) (*sdjournal.Journal, error) {
+	flags, err := ScopeFlags(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdjournal.NewJournalFromFlags(sdjournal.SD_JOURNAL_LOCAL_ONLY | flags)
+}
+
+// NamespaceFlags maps a namespace mode to the flags of sd_journal_open_namespace
+func NamespaceFlags(mode string) (int, error) {
+	switch mode {
+	case config.NamespaceModeSingle:
+		return sdjournal.SD_JOURNAL_LOCAL_ONLY, nil
+	case config.NamespaceModeAll:
+		return sdjournal.SD_JOURNAL_LOCAL_ONLY | sdjournal.SD_JOURNAL_ALL_NAMESPACES, nil
+	case config.NamespaceModeIncludeDefault:
+		return sdjournal.SD_JOURNAL_LOCAL_ONLY | sdjournal.SD_JOURNAL_INCLUDE_DEFAULT_NAMESPACE, nil
+	default:
+		return 0, fmt.Errorf("unknown namespace mode: %s", mode)
+	}
+}
+
+// OpenNamespace opens the journal of the given namespace in the given namespace mode,
+// restricted to the given scope. In the "all" mode the namespace is ignored and the
+// journals of all namespaces are opened.
+func OpenNamespace(namespace, mode, scope string) (*sdjournal.Journal, error) {
+	flags, err := NamespaceFlags(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	scopeFlags, err := ScopeFlags(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case mode == config.NamespaceModeSingle && scopeFlags == 0:
+		return sdjournal.NewJournalFromNamespace(namespace)
+	case mode == config.NamespaceModeAll:
+		namespace = ""
+	}
+
+	return sdjournal.NewJournalFromNamespaceFlags(namespace, flags|scopeFlags)
+}
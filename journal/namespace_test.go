@@ -0,0 +1,44 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"testing"
+
+	"github.com/mheese/journalbeat/config"
+	"github.com/mheese/journalbeat/journal/sdjournal"
+)
+
+func TestNamespaceFlags(t *testing.T) {
+	tests := []struct {
+		mode  string
+		flags int
+		err   bool
+	}{
+		{config.NamespaceModeSingle, sdjournal.SD_JOURNAL_LOCAL_ONLY, false},
+		{config.NamespaceModeAll, sdjournal.SD_JOURNAL_LOCAL_ONLY | sdjournal.SD_JOURNAL_ALL_NAMESPACES, false},
+		{config.NamespaceModeIncludeDefault, sdjournal.SD_JOURNAL_LOCAL_ONLY | sdjournal.SD_JOURNAL_INCLUDE_DEFAULT_NAMESPACE, false},
+		{"some", 0, true},
+	}
+	for _, test := range tests {
+		flags, err := NamespaceFlags(test.mode)
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v, want an error: %v", test.mode, err, test.err)
+		}
+		if flags != test.flags {
+			t.Errorf("%s: got flags %#x, want %#x", test.mode, flags, test.flags)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPaths replaces the glob patterns among the journal paths, e.g.
+// /archive/system@*.journal, with the paths they match, in lexical order. Other paths are
+// kept as they are. A pattern matching nothing is an error, so a mistyped pattern does not
+// silently read nothing.
+func ExpandPaths(paths []string) ([]string, error) {
+	expanded := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !strings.ContainsAny(path, "*?[") {
+			expanded = append(expanded, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("Journal path %s: %v", path, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("Journal path %s: the pattern matches no files", path)
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
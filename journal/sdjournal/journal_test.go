@@ -0,0 +1,169 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdjournal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openJournal opens the local journal at its head, skipping if it can not be opened
+func openJournal(tb testing.TB) *Journal {
+	j, err := NewJournal()
+	if err != nil {
+		tb.Skipf("Could not open the journal: %v", err)
+	}
+	if err = j.SeekHead(); err != nil {
+		j.Close()
+		tb.Fatal(err)
+	}
+	return j
+}
+
+func TestGetEntriesRefusesInvalidMax(t *testing.T) {
+	j := openJournal(t)
+	defer j.Close()
+
+	for _, max := range []int{0, -1} {
+		if entries, err := j.GetEntries(max); err == nil {
+			t.Errorf("GetEntries(%d) returned %d entries, want an error", max, len(entries))
+		}
+	}
+}
+
+func TestGetMonotonicUsecBootID(t *testing.T) {
+	j := openJournal(t)
+	defer j.Close()
+
+	if c, err := j.Next(); err != nil || c == 0 {
+		t.Skipf("The journal has no entries: %v", err)
+	}
+	entry, err := j.GetEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	usec, bootID, err := j.GetMonotonicUsecBootID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usec != entry.MonotonicTimestamp || bootID != entry.Fields[SD_JOURNAL_FIELD_BOOT_ID] {
+		t.Errorf("got %d, %s, want %d, %s", usec, bootID, entry.MonotonicTimestamp, entry.Fields[SD_JOURNAL_FIELD_BOOT_ID])
+	}
+}
+
+func TestEnumerateUniqueValues(t *testing.T) {
+	j := openJournal(t)
+	defer j.Close()
+
+	if _, err := j.EnumerateUniqueValues(""); err == nil {
+		t.Error("an empty field name was accepted")
+	}
+
+	first, err := j.EnumerateUniqueValues(SD_JOURNAL_FIELD_BOOT_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) == 0 {
+		t.Skip("The journal has no entries")
+	}
+	seen := map[string]bool{}
+	for _, value := range first {
+		if strings.Contains(value, "=") || seen[value] {
+			t.Errorf("got value %q, want a distinct value without the field name", value)
+		}
+		seen[value] = true
+	}
+
+	// the enumeration is restarted, so it can be repeated
+	second, err := j.EnumerateUniqueValues(SD_JOURNAL_FIELD_BOOT_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("got %v the second time, want %v", second, first)
+	}
+}
+
+func TestGetEntrySeqnum(t *testing.T) {
+	j := openJournal(t)
+	defer j.Close()
+
+	entries, err := j.GetEntries(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 2 {
+		t.Skip("The journal has less than 2 entries")
+	}
+
+	// the sequence number is left out before systemd 254
+	if _, err := getFunction("sd_journal_get_seqnum"); err != nil {
+		for _, entry := range entries {
+			if entry.Seqnum != 0 {
+				t.Errorf("got sequence number %d without sd_journal_get_seqnum", entry.Seqnum)
+			}
+		}
+		return
+	}
+	if entries[0].Seqnum == 0 || entries[1].Seqnum <= entries[0].Seqnum {
+		t.Errorf("got sequence numbers %d and %d, want them to increase", entries[0].Seqnum, entries[1].Seqnum)
+	}
+}
+
+// benchmarkEntries reads b.N entries with read, which returns the number of entries read
+func benchmarkEntries(b *testing.B, read func(j *Journal, n int) (int, error)) {
+	j := openJournal(b)
+	defer j.Close()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		got, err := read(j, b.N-n)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if got == 0 {
+			if n == 0 {
+				b.Skip("The journal has no entries")
+			}
+			// start over at the head for the remaining entries
+			if err = j.SeekHead(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		n += got
+	}
+}
+
+func BenchmarkGetEntry(b *testing.B) {
+	benchmarkEntries(b, func(j *Journal, n int) (int, error) {
+		c, err := j.Next()
+		if err != nil || c == 0 {
+			return 0, err
+		}
+		_, err = j.GetEntry()
+		return 1, err
+	})
+}
+
+func BenchmarkGetEntries(b *testing.B) {
+	benchmarkEntries(b, func(j *Journal, n int) (int, error) {
+		if n > 64 {
+			n = 64
+		}
+		entries, err := j.GetEntries(n)
+		return len(entries), err
+	})
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdjournal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitTimeout(t *testing.T) {
+	j, err := NewJournal()
+	if err != nil {
+		t.Skipf("Could not open the journal: %v", err)
+	}
+	defer j.Close()
+
+	// consume the entries, so only new entries could end the wait early
+	if err := j.SeekTail(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first wait sets up watching the journal files and reports a change, so the time of
+	// the first wait which does not end early is taken
+	timeout := 100 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		got := j.Wait(timeout)
+		elapsed := time.Since(start)
+		if got != SD_JOURNAL_NOP {
+			continue
+		}
+
+		if elapsed < timeout/2 || elapsed > 10*timeout {
+			t.Errorf("Wait(%v) returned after %v", timeout, elapsed)
+		}
+		return
+	}
+	t.Skip("The journal kept changing while waiting")
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultJournalDirs are the directories of the local journal files
+var DefaultJournalDirs = []string{"/var/log/journal", "/run/log/journal"}
+
+// journalFileSignature starts the header of every journal file
+var journalFileSignature = []byte("LPKSHHRH")
+
+// headerCompatibleSealed is the compatible header flag of the journal files sealed with
+// Forward Secure Sealing
+const headerCompatibleSealed = 1
+
+// JournalFiles returns the journal files of the given paths. Directories are searched for
+// journal files directly and in their subdirectories like the machine ID directories.
+func JournalFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		for _, pattern := range []string{"*.journal", "*/*.journal"} {
+			matches, _ := filepath.Glob(filepath.Join(path, pattern))
+			files = append(files, matches...)
+		}
+	}
+	return files, nil
+}
+
+// Sealed reports whether the journal file is sealed with Forward Secure Sealing, read from
+// the flags of its header
+func Sealed(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err = io.ReadFull(f, header); err != nil {
+		return false, fmt.Errorf("reading the header of %s failed: %v", file, err)
+	}
+	if !bytes.Equal(header[:8], journalFileSignature) {
+		return false, fmt.Errorf("%s is no journal file", file)
+	}
+	return binary.LittleEndian.Uint32(header[8:12])&headerCompatibleSealed != 0, nil
+}
+
+// Verify verifies the journal files with journalctl --verify, checking the seals with the
+// verification key in keyFile if given. libsystemd has no public API to verify journals.
+//
+// journalctl only takes the verification key on its command line, where every local user can
+// read it while journalctl runs. The key file itself has to be readable by its owner only.
+func Verify(files []string, keyFile string) error {
+	// journalctl verifies the whole local journal without files
+	if len(files) == 0 {
+		return fmt.Errorf("there are no journal files to verify")
+	}
+
+	args := []string{"--verify", "--quiet"}
+	if keyFile != "" {
+		fi, err := os.Stat(keyFile)
+		if err != nil {
+			return fmt.Errorf("reading the verification key failed: %v", err)
+		}
+		if fi.Mode().Perm()&0077 != 0 {
+			return fmt.Errorf("the verification key file %s is accessible by other users (mode %v)", keyFile, fi.Mode().Perm())
+		}
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("reading the verification key failed: %v", err)
+		}
+		args = append(args, "--verify-key="+strings.TrimSpace(string(key)))
+	}
+	for _, file := range files {
+		args = append(args, "--file="+file)
+	}
+
+	if out, err := exec.Command("journalctl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("verification failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Marcus Heese
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyRefusesBeforeRunningJournalctl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journalbeat-seal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	readableKey := filepath.Join(dir, "readable.key")
+	if err = ioutil.WriteFile(readableKey, []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		files   []string
+		keyFile string
+		want    string
+	}{
+		{"no files", nil, "", "no journal files"},
+		{"key readable by others", []string{"system.journal"}, readableKey, "accessible by other users"},
+		{"missing key", []string{"system.journal"}, filepath.Join(dir, "missing.key"), "reading the verification key failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.files, test.keyFile)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}